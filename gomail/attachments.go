@@ -0,0 +1,85 @@
+package gomail
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+)
+
+// AttachmentInfo describes a file attached or embedded in a message.
+type AttachmentInfo struct {
+	// Name is the file name as displayed in the message.
+	Name string
+	// ContentType is the MIME type of the file.
+	ContentType string
+	// Size is the size of the file in bytes before encoding, or -1 if it is
+	// unknown.
+	Size int64
+	// Disposition is "attachment" for attached files and "inline" for
+	// embedded images. It is empty for the emails attached with
+	// AttachMessage since they have no Content-Disposition field.
+	Disposition string
+	// ContentID is the Content-ID of the embedded images.
+	ContentID string
+
+	filename string
+	raw      []byte
+	open     func() (io.ReadCloser, error)
+}
+
+// Attachments returns the files attached or embedded in the message in the
+// order in which they are exported: the embedded images, the attachments and
+// then the attached emails. The content of the files is not read.
+func (msg *Message) Attachments() []AttachmentInfo {
+	infos := make([]AttachmentInfo, 0, len(msg.embedded)+len(msg.attachments)+len(msg.messages))
+	for _, image := range msg.embedded {
+		infos = append(infos, msg.fileInfo(image, image.name, "inline"))
+	}
+	names := msg.attachmentNames()
+	for i, a := range msg.attachments {
+		infos = append(infos, msg.fileInfo(a, names[i], "attachment"))
+	}
+	for _, m := range msg.messages {
+		info := AttachmentInfo{
+			Name:        m.name,
+			ContentType: "message/rfc822",
+			Size:        int64(len(m.raw)),
+			raw:         m.raw,
+		}
+		if m.name != "" {
+			info.Disposition = "attachment"
+		}
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+func (msg *Message) fileInfo(a attachment, name, disposition string) AttachmentInfo {
+	size, err := a.fileSize()
+	if err != nil {
+		size = -1
+	}
+
+	return AttachmentInfo{
+		Name:        name,
+		ContentType: msg.fileType(a),
+		Size:        size,
+		Disposition: disposition,
+		ContentID:   a.cid,
+		filename:    a.filename,
+		open:        a.open,
+	}
+}
+
+// Open returns the content of the attachment. The caller must close it.
+func (a AttachmentInfo) Open() (io.ReadCloser, error) {
+	if a.open != nil {
+		return a.open()
+	}
+	if a.filename == "" {
+		return ioutil.NopCloser(bytes.NewReader(a.raw)), nil
+	}
+
+	return readFile(a.filename)
+}
@@ -0,0 +1,68 @@
+package gomail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type sizedFileInfo struct {
+	stubFileInfo
+	size int64
+}
+
+func (fi sizedFileInfo) Size() int64 { return fi.size }
+
+func TestAttachments(t *testing.T) {
+	sizes := map[string]int64{"test.pdf": 1024, "image.png": 2048, "notes.txt": 16}
+	stat = func(filename string) (os.FileInfo, error) {
+		name := filepath.Base(filename)
+		return sizedFileInfo{stubFileInfo{name}, sizes[name]}, nil
+	}
+	readFile = stubReadFile
+	defer func() {
+		stat = stubStat
+	}()
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	if err := msg.Attach("/tmp/test.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	cid, err := msg.EmbedImage("/tmp/image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Attach("/tmp/notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	msg.AttachRFC822("forwarded.eml", []byte("Subject: Hello\r\n\r\nHello!"))
+
+	got := msg.Attachments()
+	want := []AttachmentInfo{
+		{Name: "image.png", ContentType: "image/png", Size: 2048, Disposition: "inline", ContentID: cid, filename: "/tmp/image.png"},
+		{Name: "test.pdf", ContentType: "application/pdf", Size: 1024, Disposition: "attachment", filename: "/tmp/test.pdf"},
+		{Name: "notes.txt", ContentType: "text/plain; charset=utf-8", Size: 16, Disposition: "attachment", filename: "/tmp/notes.txt"},
+		{Name: "forwarded.eml", ContentType: "message/rfc822", Size: 24, Disposition: "attachment", raw: []byte("Subject: Hello\r\n\r\nHello!")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Attachments() = %+v, want %+v", got, want)
+	}
+
+	for i, exp := range []string{"Content of image.png", "Content of test.pdf", "Content of notes.txt", "Subject: Hello\r\n\r\nHello!"} {
+		r, err := got[i].Open()
+		if err != nil {
+			t.Fatalf("Open(%q) returned an error: %v", got[i].Name, err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != exp {
+			t.Errorf("Open(%q) returned %q, want %q", got[i].Name, b, exp)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package gomail
+
+// The methods of this file allow building a message by chaining calls:
+//
+//	msg := gomail.NewMessage().
+//		WithFrom("alex@example.com", "Alex").
+//		WithTo("bob@example.com", "cora@example.com").
+//		WithSubject("Hello!").
+//		WithBody("text/plain", "Hello Bob and Cora!")
+
+// WithHeader sets the values of the given header field and returns the message.
+func (msg *Message) WithHeader(field string, values ...string) *Message {
+	msg.DelHeader(field)
+	for _, value := range values {
+		msg.AddHeader(field, value)
+	}
+
+	return msg
+}
+
+// WithAddressHeader sets an address to the given header field and returns the
+// message.
+func (msg *Message) WithAddressHeader(field, address, name string) *Message {
+	msg.SetAddressHeader(field, address, name)
+
+	return msg
+}
+
+// WithFrom sets the From field and returns the message.
+func (msg *Message) WithFrom(address, name string) *Message {
+	return msg.WithAddressHeader("From", address, name)
+}
+
+// WithTo sets the addresses of the To field and returns the message.
+func (msg *Message) WithTo(addresses ...string) *Message {
+	return msg.WithHeader("To", addresses...)
+}
+
+// WithCc sets the addresses of the Cc field and returns the message.
+func (msg *Message) WithCc(addresses ...string) *Message {
+	return msg.WithHeader("Cc", addresses...)
+}
+
+// WithBcc sets the addresses of the Bcc field and returns the message.
+func (msg *Message) WithBcc(addresses ...string) *Message {
+	return msg.WithHeader("Bcc", addresses...)
+}
+
+// WithSubject sets the Subject field and returns the message.
+func (msg *Message) WithSubject(subject string) *Message {
+	return msg.WithHeader("Subject", subject)
+}
+
+// WithBody sets the body of the message and returns the message.
+func (msg *Message) WithBody(contentType, body string) *Message {
+	msg.SetBody(contentType, body)
+
+	return msg
+}
+
+// WithAlternative adds an alternative body to the message and returns the
+// message.
+func (msg *Message) WithAlternative(contentType, body string) *Message {
+	msg.AddAlternative(contentType, body)
+
+	return msg
+}
@@ -0,0 +1,33 @@
+package gomail
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	want := NewMessage()
+	want.SetAddressHeader("From", "from@example.com", "Señor From")
+	want.SetHeader("To", "to1@example.com")
+	want.AddHeader("To", "to2@example.com")
+	want.SetHeader("Cc", "cc@example.com")
+	want.SetHeader("Bcc", "bcc@example.com")
+	want.SetHeader("Subject", "¡Hola, señor!")
+	want.SetHeader("X-Mailer", "gomail")
+	want.SetBody("text/plain", "Hello!")
+	want.AddAlternative("text/html", "<b>Hello!</b>")
+
+	got := NewMessage().
+		WithFrom("from@example.com", "Señor From").
+		WithTo("to1@example.com", "to2@example.com").
+		WithCc("cc@example.com").
+		WithBcc("bcc@example.com").
+		WithSubject("¡Hola, señor!").
+		WithHeader("X-Mailer", "gomail").
+		WithBody("text/plain", "Hello!").
+		WithAlternative("text/html", "<b>Hello!</b>")
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid message, got %#v, want %#v", got, want)
+	}
+}
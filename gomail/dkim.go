@@ -0,0 +1,89 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+)
+
+// BodyHash returns the hash of the exported body of the message canonicalized
+// as specified by RFC 6376, section 3.4. canonicalization must be "simple" or
+// "relaxed". It can be used to compute the bh tag of a DKIM signature. Since
+// the boundaries of multipart messages are random by default, SetBoundaryFunc
+// must be used so that the hashed body is the body that is sent.
+func (msg *Message) BodyHash(canonicalization string, h crypto.Hash) ([]byte, error) {
+	var canonicalize func([]byte) []byte
+	switch canonicalization {
+	case "simple":
+		canonicalize = simpleBody
+	case "relaxed":
+		canonicalize = relaxedBody
+	default:
+		return nil, fmt.Errorf("gomail: invalid body canonicalization %q", canonicalization)
+	}
+	if !h.Available() {
+		return nil, fmt.Errorf("gomail: unavailable hash function %v", h)
+	}
+
+	m, err := msg.Export()
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := h.New()
+	hash.Write(canonicalize(body))
+
+	return hash.Sum(nil), nil
+}
+
+// simpleBody canonicalizes a body using the simple algorithm: the empty lines
+// ending the body are removed and the body ends with a line break.
+func simpleBody(body []byte) []byte {
+	body = trimEmptyLines(body)
+
+	return append(body, '\r', '\n')
+}
+
+// relaxedBody canonicalizes a body using the relaxed algorithm: the white-space
+// ending the lines is removed, the other sequences of white-space are replaced
+// by a single space and the empty lines ending the body are removed.
+func relaxedBody(body []byte) []byte {
+	buf := new(bytes.Buffer)
+	for _, line := range bytes.SplitAfter(body, []byte("\r\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r\n"))
+		ws := false
+		for _, b := range line {
+			if b == ' ' || b == '\t' {
+				ws = true
+				continue
+			}
+			if ws {
+				buf.WriteByte(' ')
+				ws = false
+			}
+			buf.WriteByte(b)
+		}
+		buf.WriteString("\r\n")
+	}
+
+	body = trimEmptyLines(buf.Bytes())
+	if len(body) == 0 {
+		return body
+	}
+
+	return append(body, '\r', '\n')
+}
+
+// trimEmptyLines removes the line breaks ending the body.
+func trimEmptyLines(body []byte) []byte {
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+
+	return body
+}
@@ -0,0 +1,53 @@
+package gomail
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestBodyHash(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n\r\n")
+
+	tests := []struct {
+		canonicalization string
+		want             string
+	}{
+		// Example of RFC 8463, appendix A
+		{"relaxed", "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="},
+		{"simple", "4bLNXImK9drULnmePzZNEBleUanJCX5PIsDIFoH4KTQ="},
+	}
+
+	for _, test := range tests {
+		h, err := msg.BodyHash(test.canonicalization, crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := base64.StdEncoding.EncodeToString(h); got != test.want {
+			t.Errorf("Invalid %s body hash, got %q, want %q", test.canonicalization, got, test.want)
+		}
+	}
+
+	if _, err := msg.BodyHash("strict", crypto.SHA256); err == nil {
+		t.Error("BodyHash should return an error for an invalid canonicalization")
+	}
+}
+
+func TestRelaxedBody(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"\r\n\r\n", ""},
+		{" C \r\nD \t E\r\n\r\n\r\n", " C\r\nD E\r\n"},
+		{"A\t\r\nB", "A\r\nB\r\n"},
+	}
+
+	for _, test := range tests {
+		if got := string(relaxedBody([]byte(test.in))); got != test.want {
+			t.Errorf("relaxedBody(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
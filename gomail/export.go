@@ -3,12 +3,16 @@ package gomail
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
 	"net/mail"
 	"net/textproto"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/alexcesaro/mail/quotedprintable"
@@ -16,71 +20,461 @@ import (
 
 // Export converts the message into a net/mail.Message.
 func (msg *Message) Export() (*mail.Message, error) {
+	return msg.export(false, nil)
+}
+
+// export converts the message into a net/mail.Message. If binaryFiles is true,
+// the files encoded in base64 are not encoded and use the binary
+// Content-Transfer-Encoding. If report is not nil, the structure of the
+// message is reported in it.
+func (msg *Message) export(binaryFiles bool, report *Report) (*mail.Message, error) {
 	w := newMessageWriter(msg)
 
 	if msg.isMixed() {
-		w.openMultipart("mixed")
+		if err := w.openMultipart("mixed", nil); err != nil {
+			return nil, err
+		}
+	}
+	if msg.isRelated() {
+		if err := w.openMultipart("related", msg.relatedParams()); err != nil {
+			return nil, err
+		}
 	}
 	if msg.isAlternative() {
-		w.openMultipart("alternative")
+		if err := w.openMultipart(msg.bodySubtype(), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	kind := BodyPart
+	if msg.isAlternative() {
+		kind = AlternativePart
 	}
+	for _, part := range msg.alternatives() {
+		body := part.body.Bytes()
+		if !msg.rawNewlines {
+			body = normalizeNewlines(body)
+		}
 
-	for _, part := range msg.parts {
 		h := make(textproto.MIMEHeader)
-		h.Set("Content-Type", part.contentType+"; charset="+msg.charset)
-		if msg.encoding == Base64 {
-			h.Set("Content-Transfer-Encoding", Base64)
-		} else {
-			h.Set("Content-Transfer-Encoding", QuotedPrintable)
+		h.Set("Content-Type", part.contentTypeWithCharset(msg.charset))
+		encoding := part.transferEncoding(msg.encoding)
+		// A single ASCII body does not need to be encoded in quoted-printable
+		if part.encoding == "" && encoding == QuotedPrintable && kind == BodyPart && !msg.isMixed() && !msg.isRelated() && is7bit(body) {
+			encoding = SevenBit
+		}
+		h.Set("Content-Transfer-Encoding", encoding)
+		if part.description != "" {
+			h.Set("Content-Description", msg.encodeHeader(part.description))
+		}
+		if part.cid != "" {
+			h.Set("Content-ID", "<"+part.cid+">")
+		}
+		if part.filename != "" {
+			h.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": part.filename}))
 		}
 
-		w.writeHeader(h)
-		if err := w.writeBody(part.body.Bytes(), msg.encoding); err != nil {
+		msg.writePartHeader(w, kind, h)
+		if err := w.writeBody(bytes.NewReader(body), encoding); err != nil {
 			return nil, err
 		}
 	}
 	if msg.isAlternative() {
 		w.closeMultipart()
 	}
+	for _, image := range msg.embedded {
+		encoding := fileEncoding(image.encoding, binaryFiles)
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", msg.fileType(image)+"; "+quotedprintable.EncodeParam("name", image.name))
+		h.Set("Content-Disposition", "inline; "+quotedprintable.EncodeParam("filename", image.name))
+		h.Set("Content-ID", "<"+image.cid+">")
+		h.Set("Content-Transfer-Encoding", encoding)
 
-	for _, attachment := range msg.attachments {
-		mimeType := mime.TypeByExtension(filepath.Ext(attachment.name))
-		if mimeType == "" {
-			mimeType = "application/octet-stream"
+		msg.writePartHeader(w, InlinePart, h)
+		if err := w.writeFile(image, encoding); err != nil {
+			return nil, err
 		}
+	}
+	if msg.isRelated() {
+		w.closeMultipart()
+	}
 
+	names := msg.attachmentNames()
+	for i, attachment := range msg.attachments {
+		encoding := fileEncoding(attachment.encoding, binaryFiles)
 		h := make(textproto.MIMEHeader)
-		h.Set("Content-Type", mimeType+"; name=\""+attachment.name+"\"")
-		h.Set("Content-Disposition", "attachment; filename=\""+attachment.name+"\"")
-		h.Set("Content-Transfer-Encoding", Base64)
-
-		w.writeHeader(h)
-		if err := w.writeBody(attachment.content, Base64); err != nil {
+		// Attachment names can contain any character, so they are encoded as
+		// RFC 2231 parameters.
+		h.Set("Content-Type", msg.fileType(attachment)+"; "+quotedprintable.EncodeParam("name", names[i]))
+		h.Set("Content-Disposition", "attachment; "+quotedprintable.EncodeParam("filename", names[i])+attachment.dateParams())
+		h.Set("Content-Transfer-Encoding", encoding)
+
+		msg.writePartHeader(w, AttachmentPart, h)
+		if err := w.writeFile(attachment, encoding); err != nil {
 			return nil, err
 		}
 	}
+	if err := msg.writeMessages(w); err != nil {
+		return nil, err
+	}
 	if msg.isMixed() {
 		w.closeMultipart()
 	}
+	if report != nil {
+		report.Parts = w.parts
+	}
+	if msg.smimeKey != nil {
+		m, err := msg.signSMIME(w.export())
+		if err == nil && report != nil {
+			report.addSignature(m)
+		}
+		return m, err
+	}
 
 	return w.export(), nil
 }
 
+// WriteTo exports the message and writes it to w. The header fields set by
+// ReplaceHeaders are written in the given order, the other fields are sorted.
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	m, err := msg.Export()
+	if err != nil {
+		return 0, err
+	}
+
+	written := make(map[string]bool, len(msg.headerOrder))
+	fields := make([]string, 0, len(m.Header))
+	for _, field := range msg.headerOrder {
+		if _, ok := m.Header[field]; ok && !written[field] {
+			fields = append(fields, field)
+			written[field] = true
+		}
+	}
+	others := make([]string, 0, len(m.Header))
+	for field := range m.Header {
+		if !written[field] {
+			others = append(others, field)
+		}
+	}
+	sort.Strings(others)
+
+	buf := new(bytes.Buffer)
+	for _, field := range append(fields, others...) {
+		buf.WriteString(field + ": " + strings.Join(m.Header[field], ", ") + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	n, err := buf.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	nn, err := io.Copy(w, m.Body)
+
+	return n + nn, err
+}
+
+// normalizeNewlines converts the line endings of b to CRLF. Lone CR and LF
+// characters are both considered as line endings.
+func normalizeNewlines(b []byte) []byte {
+	var buf *bytes.Buffer
+	last := 0
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '\r' && i+1 < len(b) && b[i+1] == '\n':
+			i++
+			continue
+		case b[i] != '\r' && b[i] != '\n':
+			continue
+		}
+
+		if buf == nil {
+			buf = bytes.NewBuffer(make([]byte, 0, len(b)+len(b)/10))
+		}
+		buf.Write(b[last:i])
+		buf.WriteString("\r\n")
+		last = i + 1
+	}
+	if buf == nil {
+		return b
+	}
+	buf.Write(b[last:])
+
+	return buf.Bytes()
+}
+
+// is7bit returns true if b can be sent using the 7bit encoding as defined in
+// RFC 2045, section 2.7: it only contains US-ASCII characters other than NUL,
+// CR and LF only occur as CRLF line breaks and lines are at most 998
+// characters long.
+func is7bit(b []byte) bool {
+	lineLen := 0
+	for i, c := range b {
+		switch {
+		case c == 0 || c >= 0x80:
+			return false
+		case c == '\r':
+			if i+1 == len(b) || b[i+1] != '\n' {
+				return false
+			}
+			continue
+		case c == '\n':
+			if i == 0 || b[i-1] != '\r' {
+				return false
+			}
+			lineLen = 0
+			continue
+		}
+		if lineLen++; lineLen > 998 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// contentTypeWithCharset returns the content type of the part with its
+// parameters and the charset parameter set to charset if it is not already
+// set. The charset set by SetBodyCharset takes precedence over both.
+func (p part) contentTypeWithCharset(charset string) string {
+	if mediaType, params, err := mime.ParseMediaType(p.contentType); err == nil {
+		if p.charset != "" {
+			params["charset"] = p.charset
+			return mime.FormatMediaType(mediaType, params)
+		}
+		if _, ok := params["charset"]; ok {
+			return p.contentType
+		}
+	}
+	if p.charset != "" {
+		charset = p.charset
+	}
+
+	return p.contentType + "; charset=" + charset
+}
+
+// transferEncoding returns the encoding of the part if it has one or the given
+// encoding of the message otherwise.
+func (p part) transferEncoding(msgEncoding string) string {
+	if p.encoding != "" {
+		return p.encoding
+	}
+	if msgEncoding == Base64 {
+		return Base64
+	}
+
+	return QuotedPrintable
+}
+
+func (msg *Message) writePartHeader(w *messageWriter, kind PartKind, h textproto.MIMEHeader) {
+	if msg.partHeader != nil {
+		msg.partHeader(kind, h)
+	}
+	w.writeHeader(h)
+}
+
+// alternatives returns the parts of the message ordered from the least to the
+// most faithful representation of the content as required by RFC 2046, section
+// 5.1.4: text/plain comes first, then text/watch-html, text/x-amp-html which
+// must precede text/html for Gmail, text/html and then the other types. Bodies
+// that are not alternatives keep the order they were added in.
+func (msg *Message) alternatives() []part {
+	parts := make([]part, len(msg.parts))
+	copy(parts, msg.parts)
+	if msg.bodySubtype() != "alternative" {
+		return parts
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return partRank(parts[i].contentType) < partRank(parts[j].contentType)
+	})
+
+	return parts
+}
+
+func partRank(contentType string) int {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+
+	switch mediaType {
+	case "text/plain":
+		return 0
+	case WatchHTML:
+		return 1
+	case AMPHTML:
+		return 2
+	case "text/html":
+		return 3
+	}
+
+	return 4
+}
+
+// attachmentType returns the MIME type of an attached file.
+func (msg *Message) attachmentType(filename string) string {
+	var mimeType string
+	if msg.typeByName != nil {
+		mimeType = msg.typeByName(filename)
+	} else {
+		mimeType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if mimeType == "" {
+		return "application/octet-stream"
+	}
+
+	return mimeType
+}
+
+// fileType returns the MIME type of an attached or embedded file.
+func (msg *Message) fileType(a attachment) string {
+	if a.contentType != "" {
+		return a.contentType
+	}
+	mimeType := msg.attachmentType(a.name)
+	if a.charset == "" {
+		return mimeType
+	}
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeType
+	}
+	params["charset"] = a.charset
+
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// content returns the content of the attachment. It must be closed.
+func (a attachment) content() (io.ReadCloser, error) {
+	if a.open != nil {
+		return a.open()
+	}
+
+	return readFile(a.filename)
+}
+
+// fileSize returns the size of the content of the attachment.
+func (a attachment) fileSize() (int64, error) {
+	if a.open != nil {
+		return a.size, nil
+	}
+	fi, err := stat(a.filename)
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// relatedParams returns the parameters of the multipart/related part. As
+// required by RFC 2387, the type parameter is the type of the body.
+func (msg *Message) relatedParams() map[string]string {
+	switch {
+	case msg.isAlternative():
+		return map[string]string{"type": "multipart/" + msg.bodySubtype()}
+	case len(msg.parts) == 1:
+		if mediaType, _, err := mime.ParseMediaType(msg.parts[0].contentType); err == nil {
+			return map[string]string{"type": mediaType}
+		}
+	}
+
+	return nil
+}
+
+// fileEncoding returns the Content-Transfer-Encoding of a file encoded with
+// encoding. The files encoded in base64 are not encoded if binaryFiles is true.
+func fileEncoding(encoding string, binaryFiles bool) string {
+	if binaryFiles && encoding == Base64 {
+		return binary
+	}
+
+	return encoding
+}
+
+// dateParams returns the date parameters of the Content-Disposition field of
+// the attachment.
+func (a attachment) dateParams() string {
+	var params string
+	if !a.creationDate.IsZero() {
+		params += "; creation-date=\"" + a.creationDate.Format(time.RFC1123Z) + "\""
+	}
+	if !a.modificationDate.IsZero() {
+		params += "; modification-date=\"" + a.modificationDate.Format(time.RFC1123Z) + "\""
+	}
+
+	return params
+}
+
+// attachmentNames returns the names of the attachments as displayed in the
+// message, renaming the duplicate names if needed.
+func (msg *Message) attachmentNames() []string {
+	names := make([]string, len(msg.attachments))
+	used := make(map[string]bool, len(msg.attachments))
+	for i, a := range msg.attachments {
+		name := a.name
+		if msg.renameDups {
+			ext := filepath.Ext(name)
+			for n := 2; used[name]; n++ {
+				name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(a.name, ext), n, ext)
+			}
+		}
+		used[name] = true
+		names[i] = name
+	}
+
+	return names
+}
+
+// isMixed returns true if the message contains several top-level parts: the
+// body, which can have several alternatives, the attachments and the embedded
+// emails. A message having only one of them is not a multipart/mixed message
+// unless it is a single attachment and SetWrapSingleAttachment is enabled.
 func (msg *Message) isMixed() bool {
-	return (len(msg.parts) > 0 && len(msg.attachments) > 0) || len(msg.attachments) > 1
+	n := len(msg.attachments)
+	if len(msg.parts) > 0 || len(msg.embedded) > 0 {
+		n++
+	}
+	if msg.isDigest() {
+		n++
+	} else {
+		n += len(msg.messages)
+	}
+	if msg.wrapSingle && len(msg.parts) == 0 {
+		return n > 0
+	}
+
+	return n > 1
+}
+
+func (msg *Message) isRelated() bool {
+	return len(msg.embedded) > 0
 }
 
 func (msg *Message) isAlternative() bool {
 	return len(msg.parts) > 1
 }
 
+// bodySubtype returns the subtype of the multipart part containing the bodies.
+func (msg *Message) bodySubtype() string {
+	if msg.bodyType == "" {
+		return "alternative"
+	}
+
+	return msg.bodyType
+}
+
 // messageWriter helps converting the message into a net/mail.Message
 type messageWriter struct {
-	header     mail.Header
-	buf        *bytes.Buffer
-	writers    [2]*multipart.Writer
-	partWriter io.Writer
-	depth      uint8
+	header        mail.Header
+	buf           *bytes.Buffer
+	writers       [3]*multipart.Writer
+	partWriter    io.Writer
+	depth         uint8
+	base64LineLen int
+	boundary      func() string
+	// started tells if a part was created in each multipart part.
+	started [3]bool
+	// parts describes the parts written.
+	parts   []PartReport
+	maxSize int64
 }
 
 func newMessageWriter(msg *Message) *messageWriter {
@@ -94,18 +488,51 @@ func newMessageWriter(msg *Message) *messageWriter {
 		header["Mime-Version"] = []string{"1.0"}
 	}
 	if _, ok := header["Date"]; !ok {
-		header["Date"] = []string{buildDateHeader(now())}
+		clock := now
+		if msg.now != nil {
+			clock = msg.now
+		}
+		header["Date"] = []string{buildDateHeader(clock())}
+	}
+
+	base64LineLen := msg.base64LineLen
+	if msg.base64NoWrap {
+		base64LineLen = 0
+	} else if base64LineLen == 0 {
+		base64LineLen = maxBase64LineLen
 	}
 
-	return &messageWriter{header: header, buf: new(bytes.Buffer)}
+	return &messageWriter{
+		header:        header,
+		buf:           new(bytes.Buffer),
+		base64LineLen: base64LineLen,
+		boundary:      msg.boundary,
+		maxSize:       msg.maxSize,
+	}
 }
 
 // Stubbed out for testing.
 var now = time.Now
 
-func (w *messageWriter) openMultipart(mimeType string) {
+func (w *messageWriter) openMultipart(mimeType string, params map[string]string) error {
 	w.writers[w.depth] = multipart.NewWriter(w.buf)
-	contentType := "multipart/" + mimeType + "; boundary=" + w.writers[w.depth].Boundary()
+	w.started[w.depth] = false
+	if w.boundary != nil {
+		if err := w.writers[w.depth].SetBoundary(w.boundary()); err != nil {
+			return fmt.Errorf("gomail: invalid boundary: %v", err)
+		}
+	}
+	// The boundary is quoted if needed
+	if params == nil {
+		params = make(map[string]string, 1)
+	}
+	params["boundary"] = w.writers[w.depth].Boundary()
+	contentType := mime.FormatMediaType("multipart/"+mimeType, params)
+	w.parts = append(w.parts, PartReport{
+		Depth:       int(w.depth),
+		ContentType: "multipart/" + mimeType,
+		Boundary:    params["boundary"],
+	})
 
 	if w.depth == 0 {
 		w.header["Content-Type"] = []string{contentType}
@@ -115,6 +542,8 @@ func (w *messageWriter) openMultipart(mimeType string) {
 		w.createPart(h)
 	}
 	w.depth++
+
+	return nil
 }
 
 func (w *messageWriter) closeMultipart() {
@@ -125,6 +554,7 @@ func (w *messageWriter) closeMultipart() {
 }
 
 func (w *messageWriter) writeHeader(h textproto.MIMEHeader) {
+	w.parts = append(w.parts, newPartReport(int(w.depth), h))
 	if w.depth == 0 {
 		for field, value := range h {
 			w.header[field] = value
@@ -134,32 +564,100 @@ func (w *messageWriter) writeHeader(h textproto.MIMEHeader) {
 	}
 }
 
+// createPart writes the boundary and the header of a new part. The header is
+// written instead of using multipart.Writer.CreatePart, which sorts the
+// fields, because some old clients expect the fields in partFieldOrder.
 func (w *messageWriter) createPart(h textproto.MIMEHeader) {
-	// No need to check the error since the underlying writer is a bytes.Buffer
-	w.partWriter, _ = w.writers[w.depth-1].CreatePart(h)
+	if w.started[w.depth-1] {
+		w.buf.WriteString("\r\n")
+	}
+	w.started[w.depth-1] = true
+	w.buf.WriteString("--" + w.writers[w.depth-1].Boundary() + "\r\n")
+
+	fields := make([]string, 0, len(h))
+	for field := range h {
+		fields = append(fields, field)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		ri, rj := partFieldRank(fields[i]), partFieldRank(fields[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return fields[i] < fields[j]
+	})
+	for _, field := range fields {
+		for _, v := range h[field] {
+			w.buf.WriteString(field + ": " + v + "\r\n")
+		}
+	}
+	w.buf.WriteString("\r\n")
+	w.partWriter = w.buf
 }
 
-func (w *messageWriter) writeBody(body []byte, encoding string) error {
+// partFieldOrder is the order of the first fields of the header of a part, the
+// other fields are sorted.
+var partFieldOrder = []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-Id"}
+
+func partFieldRank(field string) int {
+	for i, f := range partFieldOrder {
+		if field == f {
+			return i
+		}
+	}
+
+	return len(partFieldOrder)
+}
+
+func (w *messageWriter) writeBody(body io.Reader, encoding string) error {
 	var subWriter io.Writer
 	if w.depth == 0 {
 		subWriter = w.buf
 	} else {
 		subWriter = w.partWriter
 	}
+	if w.maxSize > 0 {
+		subWriter = &sizeLimitWriter{w: subWriter, buf: w.buf, maxSize: w.maxSize}
+	}
 
-	if encoding == Base64 {
-		writer := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(subWriter))
-		// No need to check the error since base64LineWriter never returns error
-		if _, err := writer.Write(body); err != nil {
+	switch encoding {
+	case eightBit, binary:
+		if _, err := io.Copy(subWriter, body); err != nil {
+			return err
+		}
+	case SevenBit:
+		if _, err := io.Copy(&sevenBitWriter{subWriter}, body); err != nil {
+			return err
+		}
+	case Base64:
+		// The lines are not wrapped if SetBase64Wrapping is disabled.
+		if w.base64LineLen == 0 {
+			writer := base64.NewEncoder(base64.StdEncoding, subWriter)
+			if _, err := io.Copy(writer, body); err != nil {
+				return err
+			}
+			return writer.Close()
+		}
+		lineWriter := newBase64LineWriter(subWriter, w.base64LineLen)
+		writer := base64.NewEncoder(base64.StdEncoding, lineWriter)
+		if _, err := io.Copy(writer, body); err != nil {
 			return err
 		}
 		if err := writer.Close(); err != nil {
 			return err
 		}
-	} else {
-		writer := quotedprintable.NewEncoder(newQpLineWriter(subWriter))
-		// No need to check the error since qpLineWriter never returns error
-		if _, err := writer.Write(body); err != nil {
+		if err := lineWriter.Close(); err != nil {
+			return err
+		}
+	default:
+		lineWriter := newQpLineWriter(subWriter)
+		writer := quotedprintable.NewEncoder(lineWriter)
+		if _, err := io.Copy(writer, body); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		if err := lineWriter.Close(); err != nil {
 			return err
 		}
 	}
@@ -167,6 +665,17 @@ func (w *messageWriter) writeBody(body []byte, encoding string) error {
 	return nil
 }
 
+// writeFile writes the content of the given file using the given encoding.
+func (w *messageWriter) writeFile(a attachment, encoding string) error {
+	f, err := a.content()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return w.writeBody(f, encoding)
+}
+
 func (w *messageWriter) export() *mail.Message {
 	return &mail.Message{Header: w.header, Body: w.buf}
 }
@@ -174,37 +683,93 @@ func (w *messageWriter) export() *mail.Message {
 // As defined in RFC 5322, 2.1.1.
 const maxLineLen = 78
 
-// base64LineWriter limits text encoded in base64 to 78 characters per line
+// As defined in RFC 2045, 6.8. Since it is a multiple of 4, the padding
+// characters of base64 are never separated from the rest of their group.
+const maxBase64LineLen = 76
+
+// NewBase64LineWriter returns a writer that writes text encoded in base64 to w
+// and limits its lines to maxLen characters. maxLen should be a multiple of 4
+// and at most 76 as required by RFC 2045. The writer must be closed once the
+// text is written.
+func NewBase64LineWriter(w io.Writer, maxLen int) io.WriteCloser {
+	return newBase64LineWriter(w, maxLen)
+}
+
+// base64LineWriter limits text encoded in base64 to a given number of
+// characters per line
 type base64LineWriter struct {
 	w       io.Writer
 	lineLen int
+	maxLen  int
 }
 
-func newBase64LineWriter(w io.Writer) *base64LineWriter {
-	return &base64LineWriter{w: w}
+func newBase64LineWriter(w io.Writer, maxLen int) *base64LineWriter {
+	return &base64LineWriter{w: w, maxLen: maxLen}
 }
 
 func (w *base64LineWriter) Write(p []byte) (int, error) {
 	n := 0
-	for len(p)+w.lineLen > maxLineLen {
-		w.w.Write(p[:maxLineLen-w.lineLen])
-		w.w.Write([]byte("\r\n"))
-		p = p[maxLineLen-w.lineLen:]
-		n += maxLineLen - w.lineLen
+	for len(p)+w.lineLen > w.maxLen {
+		nn, err := w.w.Write(p[:w.maxLen-w.lineLen])
+		n += nn
+		if err != nil {
+			return n, err
+		}
+		if _, err := w.w.Write([]byte("\r\n")); err != nil {
+			return n, err
+		}
+		p = p[nn:]
 		w.lineLen = 0
 	}
 
-	w.w.Write(p)
-	w.lineLen += len(p)
+	nn, err := w.w.Write(p)
+	w.lineLen += nn
+
+	return n + nn, err
+}
+
+// Close resets the writer so that the next text written starts a new line. It
+// does not close the underlying writer.
+func (w *base64LineWriter) Close() error {
+	w.lineLen = 0
+
+	return nil
+}
+
+// sevenBitWriter writes text as is and returns an error if it contains 8-bit
+// characters
+type sevenBitWriter struct {
+	w io.Writer
+}
+
+func (w *sevenBitWriter) Write(p []byte) (int, error) {
+	for i, b := range p {
+		if b >= 0x80 {
+			n, _ := w.w.Write(p[:i])
+			return n, errors.New("gomail: 8-bit data cannot be sent using the 7bit encoding")
+		}
+	}
+
+	return w.w.Write(p)
+}
 
-	return n + len(p), nil
+// NewQPLineWriter returns a writer that writes text encoded in
+// quoted-printable to w and inserts soft line breaks to limit its lines to 78
+// characters. The writer must be closed once the text is written.
+func NewQPLineWriter(w io.Writer) io.WriteCloser {
+	return newQpLineWriter(w)
 }
 
 // qpLineWriter limits text encoded in quoted-printable to 78 characters per
-// line
+// line. Soft line breaks are removed when the text is decoded so breaking a
+// long word, like a URL, never alters it. An encoded byte like "=3D" is never
+// split, even if it is written using several calls to Write.
 type qpLineWriter struct {
 	w       io.Writer
 	lineLen int
+	// pending is the number of characters of an encoded byte that remain to
+	// be written.
+	pending int
 }
 
 func newQpLineWriter(w io.Writer) *qpLineWriter {
@@ -212,43 +777,66 @@ func newQpLineWriter(w io.Writer) *qpLineWriter {
 }
 
 func (w *qpLineWriter) Write(p []byte) (int, error) {
-	n := 0
-	for len(p) > 0 {
-		// If the text is not over the limit, write everything
-		if len(p) < maxLineLen-w.lineLen {
-			w.w.Write(p)
-			w.lineLen += len(p)
-			return n + len(p), nil
-		}
-
-		i := bytes.IndexAny(p[:maxLineLen-w.lineLen+2], "\n")
-		// If there is a newline before the limit, write the end of the line
-		if i != -1 && (i != maxLineLen-w.lineLen+1 || p[i-1] == '\r') {
-			w.w.Write(p[:i+1])
-			p = p[i+1:]
-			n += i + 1
+	start := 0
+	i := 0
+	// The end of an encoded byte started by the previous call is written
+	// without line break.
+	for ; i < len(p) && w.pending > 0; i++ {
+		w.pending--
+	}
+	for i < len(p) {
+		size, width := 1, 1
+		switch p[i] {
+		case '\n':
 			w.lineLen = 0
+			i++
 			continue
+		case '\r':
+			// A carriage return followed by a line feed is a hard line break
+			// and does not count in the line length. A carriage return ending
+			// p is assumed to be followed by a line feed.
+			if i+1 == len(p) || p[i+1] == '\n' {
+				width = 0
+			}
+		case '=':
+			// Quoted-printable text must not be cut between an equal sign and
+			// the two following characters
+			width = 3
+			if size = 3; i+size > len(p) {
+				size = len(p) - i
+				w.pending = 3 - size
+			}
 		}
 
-		// Quoted-printable text must not be cut between an equal sign and the
-		// two following characters
-		var toWrite int
-		if maxLineLen-w.lineLen-2 >= 0 && p[maxLineLen-w.lineLen-2] == '=' {
-			toWrite = maxLineLen - w.lineLen - 2
-		} else if p[maxLineLen-w.lineLen-1] == '=' {
-			toWrite = maxLineLen - w.lineLen - 1
-		} else {
-			toWrite = maxLineLen - w.lineLen
+		// Insert a soft line break where it is needed
+		if w.lineLen+width > maxLineLen {
+			if _, err := w.w.Write(p[start:i]); err != nil {
+				return start, err
+			}
+			if _, err := w.w.Write([]byte("=\r\n")); err != nil {
+				return i, err
+			}
+			start = i
+			w.lineLen = 0
 		}
+		w.lineLen += width
+		i += size
+	}
+	if _, err := w.w.Write(p[start:]); err != nil {
+		return start, err
+	}
 
-		// Insert the newline where it is needed
-		w.w.Write(p[:toWrite])
-		w.w.Write([]byte("=\r\n"))
-		p = p[toWrite:]
-		n += toWrite
-		w.lineLen = 0
+	return len(p), nil
+}
+
+// Close returns an error if the text ends with an incomplete encoded byte and
+// resets the writer. It does not close the underlying writer.
+func (w *qpLineWriter) Close() error {
+	pending := w.pending
+	w.lineLen, w.pending = 0, 0
+	if pending > 0 {
+		return errors.New("gomail: quoted-printable text ends with an incomplete encoded byte")
 	}
 
-	return n, nil
+	return nil
 }
@@ -0,0 +1,48 @@
+package gomail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path"
+	"strings"
+)
+
+// AttachFormFile attaches a file uploaded in a multipart/form-data request, as
+// returned by net/http.Request.FormFile. The file is named after the filename
+// sent by the client without its directories and its MIME type is the one sent
+// by the client, or else is deduced from its name. Like with Attach, the file
+// is read when the message is exported so the form must not be removed before.
+func (msg *Message) AttachFormFile(fh *multipart.FileHeader) error {
+	// The filename is sent by the client, it can be a Windows path.
+	name := path.Base(strings.Replace(fh.Filename, `\`, "/", -1))
+	if name == "." || name == ".." || name == "/" {
+		return errors.New("gomail: cannot attach a form file without filename")
+	}
+	if msg.maxAttachments > 0 && len(msg.attachments) >= msg.maxAttachments {
+		return fmt.Errorf("gomail: cannot attach %q: the message already has %d attachments", fh.Filename, len(msg.attachments))
+	}
+	if msg.rejectEmpty && fh.Size == 0 {
+		return fmt.Errorf("gomail: cannot attach %q: empty file", fh.Filename)
+	}
+
+	var contentType string
+	if ct := fh.Header.Get("Content-Type"); ct != "" {
+		if mediaType, params, err := mime.ParseMediaType(ct); err == nil {
+			contentType = mime.FormatMediaType(mediaType, params)
+		}
+	}
+	msg.attachments = append(msg.attachments, attachment{
+		name:     name,
+		encoding: Base64,
+		open: func() (io.ReadCloser, error) {
+			return fh.Open()
+		},
+		contentType: contentType,
+		size:        fh.Size,
+	})
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package gomail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAttachFormFile(t *testing.T) {
+	form := newForm(t, []formFile{
+		{"photo.jpg", "image/png", "\x89PNG"},
+		{"notes.txt", "", "Hello"},
+	})
+	defer form.RemoveAll()
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	for _, fh := range form.File["file"] {
+		if err := msg.AttachFormFile(fh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := new(bytes.Buffer)
+	body.ReadFrom(m.Body)
+	for _, want := range []string{
+		"Content-Type: image/png; name=\"photo.jpg\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"Content-Disposition: attachment; filename=\"photo.jpg\"\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("\x89PNG")) + "\r\n",
+		"Content-Type: text/plain; charset=utf-8; name=\"notes.txt\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"Content-Disposition: attachment; filename=\"notes.txt\"\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Hello")) + "\r\n",
+	} {
+		if !strings.Contains(body.String(), want) {
+			t.Errorf("Invalid attachment, got:\n%s\nwant:\n%s", body.String(), want)
+		}
+	}
+
+	if got := msg.Attachments(); len(got) != 2 || got[0].Size != 4 || got[1].Size != 5 {
+		t.Errorf("Invalid attachments: %+v", got)
+	}
+	if err := msg.AttachFormFile(&multipart.FileHeader{}); err == nil {
+		t.Error("AttachFormFile should return an error when the file has no filename")
+	}
+}
+
+func TestAttachFormFileName(t *testing.T) {
+	names := []string{`a".exe`, "a; b.exe", "résumé.pdf"}
+	files := make([]formFile, len(names))
+	for i, name := range names {
+		files[i] = formFile{filename: name, content: "Hello"}
+	}
+	form := newForm(t, files)
+	defer form.RemoveAll()
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	for _, fh := range form.File["file"] {
+		if err := msg.AttachFormFile(fh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = WalkParts(m, func(h textproto.MIMEHeader, body io.Reader) error {
+		_, typeParams, err := mime.ParseMediaType(h.Get("Content-Type"))
+		if err != nil {
+			return err
+		}
+		if typeParams["name"] == "" {
+			return nil
+		}
+		_, params, err := mime.ParseMediaType(h.Get("Content-Disposition"))
+		if err != nil {
+			return err
+		}
+		if params["filename"] != typeParams["name"] {
+			t.Errorf("The filename %q differs from the name %q", params["filename"], typeParams["name"])
+		}
+		got = append(got, params["filename"])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, names) {
+		t.Errorf("Invalid filenames, got %q, want %q", got, names)
+	}
+}
+
+func TestAttachFormFilePath(t *testing.T) {
+	form := newForm(t, []formFile{{filename: "x.exe", content: "Hello"}})
+	defer form.RemoveAll()
+	fh := form.File["file"][0]
+
+	msg := NewMessage()
+	for _, filename := range []string{`..\..\x.exe`, "/etc/passwd", "C:\\Users\\Bob\\notes.txt", "a/b/"} {
+		fh.Filename = filename
+		if err := msg.AttachFormFile(fh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, a := range msg.Attachments() {
+		got = append(got, a.Name)
+	}
+	if want := []string{"x.exe", "passwd", "notes.txt", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid attachment names, got %q, want %q", got, want)
+	}
+
+	for _, filename := range []string{"", "/", `..\`, "a/.."} {
+		fh.Filename = filename
+		if err := msg.AttachFormFile(fh); err == nil {
+			t.Errorf("AttachFormFile should return an error when the filename is %q", filename)
+		}
+	}
+}
+
+type formFile struct {
+	filename, contentType, content string
+}
+
+// newForm returns a multipart/form-data form containing the given files in its
+// file field.
+func newForm(t *testing.T, files []formFile) *multipart.Form {
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+	for _, f := range files {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": f.filename}))
+		if f.contentType != "" {
+			h.Set("Content-Type", f.contentType)
+		}
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pw.Write([]byte(f.content))
+	}
+	w.Close()
+	form, err := multipart.NewReader(buf, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return form
+}
@@ -33,11 +33,20 @@ package gomail
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"mime"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/alexcesaro/mail/mailer"
 	"github.com/alexcesaro/mail/quotedprintable"
@@ -49,6 +58,14 @@ const (
 	QuotedPrintable = "quoted-printable"
 	// Base64 represents the base64 encoding as defined in RFC 2045.
 	Base64 = "base64"
+	// SevenBit represents the 7bit encoding as defined in RFC 2045, it means
+	// the content is sent as is and only contains US-ASCII characters.
+	SevenBit = "7bit"
+	// eightBit is only used for the parts that must not be encoded.
+	eightBit = "8bit"
+	// binary is only used for the files sent to the SMTP servers supporting
+	// the BINARYMIME extension, see SetBinaryAttachments.
+	binary = "binary"
 )
 
 // Message represents a mail message.
@@ -56,65 +73,431 @@ type Message struct {
 	header      header
 	parts       []part
 	attachments []attachment
+	embedded    []attachment
+	headerOrder []string
 	charset     string
 	encoding    string
+	hEncoding   string
 	hEncoder    *quotedprintable.HeaderEncoder
+	partHeader  func(PartKind, textproto.MIMEHeader)
+	rawNewlines bool
+	renameDups  bool
+	rejectEmpty bool
+	// singleSubject is true if the subject is encoded into a single
+	// encoded-word.
+	singleSubject bool
+	// trimValues is true if the whitespace surrounding the header values is
+	// removed.
+	trimValues bool
+	// maxAttachments is the maximum number of attachments, 0 means there is
+	// no limit.
+	maxAttachments int
+	// base64LineLen is the length of the lines encoded in base64, 0 means
+	// the default length is used.
+	base64LineLen int
+	// base64NoWrap is true if the parts encoded in base64 are not wrapped.
+	base64NoWrap bool
+	// now returns the current time used in the Date field, nil means
+	// time.Now is used.
+	now func() time.Time
+	// messages are the emails embedded in message/rfc822 parts.
+	messages []embeddedMessage
+	digest   bool
+	// typeByName returns the MIME type of an attachment from its name, nil
+	// means mime.TypeByExtension is used.
+	typeByName func(filename string) string
+	wrapSingle bool
+	// boundary returns the boundaries of the multipart parts, nil means random
+	// boundaries are used.
+	boundary func() string
+	// bodyType is the subtype of the multipart part containing the bodies,
+	// an empty string means multipart/alternative is used.
+	bodyType string
+	// smimeCert and smimeKey are used to sign the message, see
+	// SetSMIMESigner.
+	smimeCert *x509.Certificate
+	smimeKey  crypto.Signer
+	// binaryFiles is true if the files are sent without being encoded to
+	// the SMTP servers supporting it.
+	binaryFiles bool
+	// maxSize is the maximum size of the exported body, see SetMaxSize.
+	maxSize int64
 }
 
+// PartKind is the kind of a MIME part of a message.
+type PartKind int
+
+const (
+	// BodyPart is the body of a message that has only one body.
+	BodyPart PartKind = iota
+	// AlternativePart is one of the bodies of a message that has several
+	// bodies, which are alternatives unless SetBodyMultipartType is used.
+	AlternativePart
+	// AttachmentPart is a file attached to the message.
+	AttachmentPart
+	// InlinePart is a file displayed inline, like an image referenced from an
+	// HTML body.
+	InlinePart
+	// MessagePart is an email embedded in the message.
+	MessagePart
+)
+
 type header map[string][]string
 
 type part struct {
 	contentType string
 	body        *bytes.Buffer
+	// encoding is the Content-Transfer-Encoding of the part, an empty string
+	// means the encoding of the message is used.
+	encoding    string
+	description string
+	// charset overrides the charset parameter of the part, if set.
+	charset string
+	cid     string
+	// filename is the filename parameter of the Content-Disposition field of
+	// the part, if set.
+	filename string
 }
 
 type attachment struct {
-	name    string
-	content []byte
+	name             string
+	filename         string
+	encoding         string
+	cid              string
+	creationDate     time.Time
+	modificationDate time.Time
+	// open, contentType and size are set if the attachment is not a file
+	// read from the file system, see AttachFormFile.
+	open        func() (io.ReadCloser, error)
+	contentType string
+	size        int64
+	charset     string
 }
 
 // NewCustomMessage creates a new message that will use the given encoding and
 // charset.
 func NewCustomMessage(charset, encoding string) *Message {
-	var enc string
-	if encoding == Base64 {
-		enc = quotedprintable.B
-	} else {
-		enc = quotedprintable.Q
-	}
-
-	// No error will be thrown since we are using existing encodings
-	encoder, _ := quotedprintable.NewHeaderEncoder(charset, enc)
-
 	return &Message{
 		header:      make(header),
 		parts:       make([]part, 0),
 		attachments: make([]attachment, 0),
 		charset:     charset,
 		encoding:    encoding,
-		hEncoder:    encoder,
+		hEncoding:   headerEncoding(encoding),
+		hEncoder:    newHeaderEncoder(charset, headerEncoding(encoding)),
 	}
 }
 
+// headerEncoding returns the RFC 2047 encoding used by default for the header
+// of a message using the given encoding.
+func headerEncoding(encoding string) string {
+	if encoding == Base64 {
+		return quotedprintable.B
+	}
+
+	return quotedprintable.Q
+}
+
+func newHeaderEncoder(charset, enc string) *quotedprintable.HeaderEncoder {
+	// No error will be thrown since we are using existing encodings
+	encoder, _ := quotedprintable.NewHeaderEncoder(charset, enc)
+
+	return encoder
+}
+
 // NewMessage creates a new UTF-8 message using quoted-printable encoding.
 func NewMessage() *Message {
 	return NewCustomMessage("UTF-8", QuotedPrintable)
 }
 
+// SetEncoding sets the encoding used by the message. It can be QuotedPrintable
+// or Base64. The header fields are then encoded using respectively the Q or B
+// encoding of RFC 2047. The header fields that were already set are not
+// encoded again.
+func (msg *Message) SetEncoding(encoding string) error {
+	if encoding != QuotedPrintable && encoding != Base64 {
+		return fmt.Errorf("gomail: unsupported encoding: %q", encoding)
+	}
+	msg.encoding = encoding
+	msg.hEncoding = headerEncoding(encoding)
+	msg.hEncoder = newHeaderEncoder(msg.charset, msg.hEncoding)
+
+	return nil
+}
+
+// SetHeaderEncoding sets the encoding used for the header fields independently
+// of the encoding of the bodies. It can be quotedprintable.Q or
+// quotedprintable.B. SetEncoding resets the header encoding so it must be
+// called before SetHeaderEncoding. The header fields that were already set are
+// not encoded again.
+func (msg *Message) SetHeaderEncoding(enc string) error {
+	encoder, err := quotedprintable.NewHeaderEncoder(msg.charset, enc)
+	if err != nil {
+		return err
+	}
+	msg.hEncoding = enc
+	msg.hEncoder = encoder
+
+	return nil
+}
+
+// SetCharset sets the charset used by the message. The message content is not
+// converted: the header fields and the bodies must be written in this charset.
+// The header fields that were already set are not encoded again.
+func (msg *Message) SetCharset(charset string) error {
+	if !isValidCharset(charset) {
+		return fmt.Errorf("gomail: invalid charset: %q", charset)
+	}
+	msg.charset = charset
+	msg.hEncoder = newHeaderEncoder(charset, msg.hEncoding)
+
+	return nil
+}
+
+// isValidCharset returns true if charset is a valid mime-charset as defined in
+// RFC 2978, section 2.3.
+func isValidCharset(charset string) bool {
+	if charset == "" {
+		return false
+	}
+	for _, c := range charset {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') &&
+			!strings.ContainsRune("!#$%&'+-^_`{}~", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SetPartHeaderFunc sets a function called by Export with the header of each
+// MIME part before it is written. The function can modify the header, for
+// example to add a custom field. When the message has only one part, the header
+// of the part is merged into the header of the message.
+func (msg *Message) SetPartHeaderFunc(f func(kind PartKind, h textproto.MIMEHeader)) {
+	msg.partHeader = f
+}
+
+// SetNormalizeNewlines sets whether the line endings of the bodies are converted
+// to CRLF when the message is exported, as required by RFC 5322. It is enabled
+// by default.
+func (msg *Message) SetNormalizeNewlines(normalize bool) {
+	msg.rawNewlines = !normalize
+}
+
+// SetRenameDuplicateAttachments sets whether attachments having the same name
+// are renamed when the message is exported. If enabled, " (2)", " (3)", etc.
+// are added before the extension of the names that were already used, so that
+// report.pdf becomes report (2).pdf. It is disabled by default.
+func (msg *Message) SetRenameDuplicateAttachments(rename bool) {
+	msg.renameDups = rename
+}
+
+// SetRejectEmptyFiles sets whether Attach, AttachWithOptions and EmbedImage
+// return an error when the file is empty. An empty file is often a bug, like a
+// file that is not written yet, and some clients display it as a broken
+// attachment. It is disabled by default.
+func (msg *Message) SetRejectEmptyFiles(reject bool) {
+	msg.rejectEmpty = reject
+}
+
+// SetMaxAttachments sets the maximum number of files that can be attached to
+// the message. Attach and AttachWithOptions return an error once the limit is
+// reached. There is no limit by default or if n is not positive.
+func (msg *Message) SetMaxAttachments(n int) {
+	msg.maxAttachments = n
+}
+
+// SetBase64Wrapping sets whether the lines of the parts encoded in base64 are
+// wrapped, which is the default. When disabled, each part is encoded as a
+// single line, which some HTTP mail gateways require. Such messages exceed the
+// 998 characters limit of RFC 5322 and must not be sent over SMTP.
+func (msg *Message) SetBase64Wrapping(wrap bool) {
+	msg.base64NoWrap = !wrap
+}
+
+// SetBase64LineLength sets the maximum length of the lines of the parts encoded
+// in base64. It must be a multiple of 4 so that lines are wrapped between two
+// groups of base64 characters, and at most 76 as required by RFC 2045, which is
+// the default.
+func (msg *Message) SetBase64LineLength(n int) error {
+	if n <= 0 || n%4 != 0 || n > maxBase64LineLen {
+		return fmt.Errorf("gomail: invalid base64 line length: %d", n)
+	}
+	msg.base64LineLen = n
+
+	return nil
+}
+
+// SetWrapSingleAttachment sets whether a message without body and with a single
+// attachment is exported as a multipart/mixed message containing the
+// attachment. By default, it is disabled and the attachment is the top-level
+// part of the message.
+func (msg *Message) SetWrapSingleAttachment(wrap bool) {
+	msg.wrapSingle = wrap
+}
+
+// SetBodyMultipartType sets the subtype of the multipart part containing the
+// bodies of a message having several bodies. It must be "alternative", which is
+// the default, "mixed" or "related". The bodies of a multipart/mixed part are
+// displayed in sequence, in the order they were added.
+func (msg *Message) SetBodyMultipartType(subtype string) error {
+	switch subtype {
+	case "alternative", "mixed", "related":
+	default:
+		return fmt.Errorf("gomail: invalid multipart subtype for the bodies %q", subtype)
+	}
+	msg.bodyType = subtype
+
+	return nil
+}
+
+// SetBoundaryFunc sets the function called to get the boundary of each
+// multipart part when the message is exported, for example to get reproducible
+// messages. The boundaries must be valid as defined in RFC 2046 and must not
+// appear in the content of the message. By default, random boundaries are
+// used.
+func (msg *Message) SetBoundaryFunc(f func() string) {
+	msg.boundary = f
+}
+
+// SetMIMETypeResolver sets the function returning the MIME type of an
+// attachment from its name. If it returns an empty string,
+// application/octet-stream is used. By default, the type is found using
+// mime.TypeByExtension which depends on the MIME database of the system.
+func (msg *Message) SetMIMETypeResolver(f func(filename string) string) {
+	msg.typeByName = f
+}
+
+// SetNow sets the function returning the current time used to set the Date
+// field when the message is exported without one. It defaults to time.Now.
+func (msg *Message) SetNow(now func() time.Time) {
+	msg.now = now
+}
+
 // SetHeader sets a value to the given header field.
 func (msg *Message) SetHeader(field, value string) {
-	msg.header[field] = []string{msg.encodeHeader(value)}
+	msg.header[field] = []string{msg.encodeField(field, value)}
 }
 
 // AddHeader adds a value to the given header field.
 func (msg *Message) AddHeader(field, value string) {
-	msg.header[field] = append(msg.header[field], msg.encodeHeader(value))
+	msg.header[field] = append(msg.header[field], msg.encodeField(field, value))
+}
+
+// SetTrimHeaderValues sets whether the leading and trailing whitespace of the
+// values passed to SetHeader and AddHeader is removed before they are encoded.
+// The whitespace inside the values is kept. It is disabled by default.
+func (msg *Message) SetTrimHeaderValues(trim bool) {
+	msg.trimValues = trim
+}
+
+// SetSingleWordSubject sets whether a non-ASCII subject is encoded into a
+// single encoded-word. If enabled, a long subject is not split into several
+// encoded-words on several lines, which some clients fail to decode, but the
+// encoded-word can be longer than the 75 characters allowed by RFC 2047. It
+// only applies to the subjects set after it is enabled and it is disabled by
+// default.
+func (msg *Message) SetSingleWordSubject(single bool) {
+	msg.singleSubject = single
+}
+
+// SetOrganization sets the Organization field, the name of the organization
+// the sender belongs to.
+func (msg *Message) SetOrganization(name string) {
+	msg.SetHeader("Organization", name)
+}
+
+// SetMailerName sets the User-Agent field, the name of the software used to
+// write the message.
+func (msg *Message) SetMailerName(name string) {
+	msg.SetHeader("User-Agent", name)
+}
+
+// SetAutoSubmitted sets the Auto-Submitted field defined in RFC 3834. value
+// must be "no", "auto-generated" or "auto-replied". Automatic responders like
+// vacation programs do not reply to messages marked as automatically
+// submitted.
+func (msg *Message) SetAutoSubmitted(value string) error {
+	switch value {
+	case "no", "auto-generated", "auto-replied":
+	default:
+		return fmt.Errorf("gomail: invalid Auto-Submitted value %q", value)
+	}
+	msg.header["Auto-Submitted"] = []string{value}
+
+	return nil
+}
+
+// SetSubjectTruncated sets the subject of the message. If the subject is longer
+// than maxRunes characters, it is truncated and ends with an ellipsis so that
+// it is exactly maxRunes characters long.
+func (msg *Message) SetSubjectTruncated(s string, maxRunes int) {
+	msg.SetHeader("Subject", truncate(s, maxRunes))
+}
+
+func truncate(s string, maxRunes int) string {
+	if utf8.RuneCountInString(s) <= maxRunes {
+		return s
+	}
+	if maxRunes <= 0 {
+		return ""
+	}
+
+	n := 0
+	for i := range s {
+		if n == maxRunes-1 {
+			return s[:i] + ellipsis
+		}
+		n++
+	}
+
+	return s
+}
+
+const ellipsis = "…"
+
+// SetRawHeader sets a value to the given header field without encoding it. The
+// caller is responsible for the validity of the value: it must be correctly
+// encoded and must not contain CR or LF characters except when folding.
+func (msg *Message) SetRawHeader(field, value string) {
+	msg.header[field] = []string{value}
+}
+
+// AddRawHeader adds a value to the given header field without encoding it. See
+// SetRawHeader.
+func (msg *Message) AddRawHeader(field, value string) {
+	msg.header[field] = append(msg.header[field], value)
+}
+
+// ReplaceHeaders replaces all the header fields of the message with the fields
+// of h. The values are not encoded, see SetRawHeader. The fields listed in
+// order are written first and in this order by WriteTo, the other fields are
+// written after them in alphabetical order.
+func (msg *Message) ReplaceHeaders(order []string, h map[string][]string) {
+	msg.header = make(header, len(h))
+	for field, values := range h {
+		msg.header[field] = append([]string(nil), values...)
+	}
+	msg.headerOrder = append([]string(nil), order...)
 }
 
 func (msg *Message) encodeHeader(value string) string {
 	return msg.hEncoder.EncodeHeader(value)
 }
 
+// encodeField encodes the value of the given header field.
+func (msg *Message) encodeField(field, value string) string {
+	if msg.trimValues {
+		value = strings.TrimSpace(value)
+	}
+	if msg.singleSubject && field == "Subject" {
+		return msg.hEncoder.EncodeHeaderSingleWord(value)
+	}
+
+	return msg.encodeHeader(value)
+}
+
 // SetAddressHeader sets an address to the given header field.
 func (msg *Message) SetAddressHeader(field, address, name string) {
 	msg.header[field] = []string{msg.buildAddressHeader(address, name)}
@@ -125,8 +508,150 @@ func (msg *Message) AddAddressHeader(field, address, name string) {
 	msg.header[field] = append(msg.header[field], msg.buildAddressHeader(address, name))
 }
 
+// buildAddressHeader returns an address with its display name. A non-ASCII name
+// is encoded while an ASCII name containing special characters, like
+// "Doe, John", is quoted (see RFC 5322, section 3.2.5).
 func (msg *Message) buildAddressHeader(address, name string) string {
-	return msg.encodeHeader(name) + " <" + address + ">"
+	if !needsQuoting(name) {
+		return msg.hEncoder.EncodeHeaderPhrase(name) + " <" + address + ">"
+	}
+
+	return quoteString(name) + " <" + address + ">"
+}
+
+// needsQuoting returns true if name only contains printable ASCII characters
+// and some of them are not allowed in an atom or look like an encoded-word.
+func needsQuoting(name string) bool {
+	special := strings.Contains(name, "=?")
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < ' ' || c > '~' {
+			return false
+		}
+		if c != ' ' && !isAtext(c) {
+			special = true
+		}
+	}
+
+	return special
+}
+
+// isAtext returns true if c can be used in an atom as defined in RFC 5322,
+// section 3.2.3.
+func isAtext(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) != -1
+}
+
+// quoteString returns s as a quoted-string.
+func quoteString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+	buf.WriteByte('"')
+
+	return buf.String()
+}
+
+// ListOptions describes a mailing list for SetListHeaders.
+type ListOptions struct {
+	// ID is the identifier of the list, like "list.example.com".
+	ID string
+	// Description is the name of the list displayed with its identifier.
+	Description string
+	// Post, Archive and Help are the URLs, like "mailto:list@example.com", used
+	// to post to the list, to get its archives and to get help.
+	Post    []string
+	Archive []string
+	Help    []string
+}
+
+// SetListHeaders sets the List-Id field defined in RFC 2919 and the List-Post,
+// List-Archive and List-Help fields defined in RFC 2369 from the given options.
+// The fields having no URL are not set.
+func (msg *Message) SetListHeaders(opts ListOptions) error {
+	if opts.ID == "" || strings.ContainsAny(opts.ID, "<> \t\r\n") {
+		return fmt.Errorf("gomail: invalid list identifier: %q", opts.ID)
+	}
+	// The fields are only set once all the options are validated so that the
+	// message is left unchanged on error.
+	fields := make(map[string]string, 4)
+	if opts.Description == "" {
+		fields["List-Id"] = "<" + opts.ID + ">"
+	} else {
+		fields["List-Id"] = msg.buildAddressHeader(opts.ID, opts.Description)
+	}
+
+	for _, f := range []struct {
+		field string
+		urls  []string
+	}{
+		{"List-Post", opts.Post},
+		{"List-Archive", opts.Archive},
+		{"List-Help", opts.Help},
+	} {
+		if len(f.urls) == 0 {
+			continue
+		}
+		values := make([]string, len(f.urls))
+		for i, u := range f.urls {
+			if strings.ContainsAny(u, "<> \t\r\n") {
+				return fmt.Errorf("gomail: invalid %s URL: %q", f.field, u)
+			}
+			values[i] = "<" + u + ">"
+		}
+		fields[f.field] = strings.Join(values, ", ")
+	}
+	for field, value := range fields {
+		msg.header[field] = []string{value}
+	}
+
+	return nil
+}
+
+// SetReturnPath sets the address used by the mailer as the envelope sender of
+// the message instead of the Sender or From address. Bounces are sent to this
+// address.
+func (msg *Message) SetReturnPath(address string) error {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("gomail: invalid Return-Path address %q: %v", address, err)
+	}
+	msg.header["Return-Path"] = []string{"<" + addr.Address + ">"}
+
+	return nil
+}
+
+// RequestReadReceipt asks the recipients to send a read receipt to the given
+// address by setting the Disposition-Notification-To field defined in RFC
+// 8098. The address can contain a display name, like "Alex <alex@example.com>".
+func (msg *Message) RequestReadReceipt(address string) error {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("gomail: invalid read receipt address %q: %v", address, err)
+	}
+	if addr.Name == "" {
+		msg.header["Disposition-Notification-To"] = []string{addr.Address}
+	} else {
+		msg.SetAddressHeader("Disposition-Notification-To", addr.Address, addr.Name)
+	}
+
+	return nil
+}
+
+// ReturnPath returns the address set by SetReturnPath or an empty string if
+// there is none.
+func (msg *Message) ReturnPath() string {
+	if v := msg.header["Return-Path"]; len(v) > 0 {
+		return strings.Trim(v[0], "<>")
+	}
+
+	return ""
 }
 
 // SetDateHeader sets a date to the given header field.
@@ -153,19 +678,230 @@ func (msg *Message) DelHeader(field string) {
 	delete(msg.header, field)
 }
 
-// SetBody sets the body of the message.
+// DelHeaderValue deletes the values of the given header field that are equal
+// to value, either as they were encoded or once decoded. The field is deleted
+// if it has no value left. It returns true if a value was deleted.
+func (msg *Message) DelHeaderValue(field, value string) bool {
+	values := msg.header[field]
+	var kept []string
+	for _, v := range values {
+		if v == value {
+			continue
+		}
+		if dec, _, err := quotedprintable.DecodeHeader(v); err == nil && dec == value {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	if len(kept) == len(values) {
+		return false
+	}
+	if len(kept) == 0 {
+		delete(msg.header, field)
+	} else {
+		msg.header[field] = kept
+	}
+
+	return true
+}
+
+// SetBody sets the body of the message. The content type can contain
+// parameters, like "text/plain; format=flowed". The charset parameter is added
+// when exporting the message unless it is already set.
 func (msg *Message) SetBody(contentType, body string) {
-	msg.parts = []part{part{contentType, bytes.NewBufferString(body)}}
+	msg.parts = []part{{contentType: contentType, body: bytes.NewBufferString(body)}}
 }
 
 // AddAlternative adds an alternative body to the message. Usually used to
 // provide both an HTML and a text version of the message.
 func (msg *Message) AddAlternative(contentType, body string) {
-	msg.parts = append(msg.parts, part{contentType, bytes.NewBufferString(body)})
+	msg.parts = append(msg.parts, part{contentType: contentType, body: bytes.NewBufferString(body)})
+}
+
+// SetBodies sets the body of the message to a plain text version and an HTML
+// alternative. It is equivalent to:
+//
+//	msg.SetBody("text/plain", text)
+//	msg.AddAlternative("text/html", html)
+func (msg *Message) SetBodies(text, html string) {
+	msg.SetBody("text/plain", text)
+	msg.AddAlternative("text/html", html)
+}
+
+// SetBodyCharset sets the charset parameter of the body having the given
+// content type, overriding the charset of the message and the charset parameter
+// of the content type. It returns an error if the message has no such body.
+//
+// Warning: the body is not converted. It is meant as a workaround for legacy
+// clients, labeling a body with a charset it is not written in makes other
+// clients display it incorrectly. Validate reports the bodies labeled with a
+// charset other than UTF-8 that contain UTF-8 text.
+func (msg *Message) SetBodyCharset(contentType, charset string) error {
+	if !isValidCharset(charset) {
+		return fmt.Errorf("gomail: invalid charset: %q", charset)
+	}
+	p, err := msg.bodyPart(contentType)
+	if err != nil {
+		return err
+	}
+	p.charset = charset
+
+	return nil
+}
+
+// SetBodyEncoding sets the Content-Transfer-Encoding of the body having the
+// given content type, overriding the encoding of the message. It can be
+// Base64, QuotedPrintable or SevenBit. It returns an error if the message has
+// no such body.
+func (msg *Message) SetBodyEncoding(contentType, encoding string) error {
+	switch encoding {
+	case Base64, QuotedPrintable, SevenBit:
+	default:
+		return fmt.Errorf("gomail: unsupported body encoding: %q", encoding)
+	}
+	p, err := msg.bodyPart(contentType)
+	if err != nil {
+		return err
+	}
+	p.encoding = encoding
+
+	return nil
+}
+
+// SetBodyDescription sets the Content-Description field of the body having the
+// given content type, like "text/html". It returns an error if the message has
+// no such body.
+func (msg *Message) SetBodyDescription(contentType, description string) error {
+	p, err := msg.bodyPart(contentType)
+	if err != nil {
+		return err
+	}
+	p.description = description
+
+	return nil
+}
+
+// SetBodyContentID sets the Content-ID field of the body having the given
+// content type so that it can be referenced by the other parts of a
+// multipart/related message using a cid URL. cid must not be enclosed in angle
+// brackets. It returns an error if the message has no such body.
+func (msg *Message) SetBodyContentID(contentType, cid string) error {
+	if cid == "" || strings.ContainsAny(cid, "<> \t\r\n") {
+		return fmt.Errorf("gomail: invalid Content-ID: %q", cid)
+	}
+	p, err := msg.bodyPart(contentType)
+	if err != nil {
+		return err
+	}
+	p.cid = cid
+
+	return nil
+}
+
+// SetBodyFilename sets the Content-Disposition field of the body having the
+// given content type to inline with the given filename parameter, which some
+// clients display. It returns an error if the message has no such body.
+func (msg *Message) SetBodyFilename(contentType, filename string) error {
+	if filename == "" || mime.FormatMediaType("inline", map[string]string{"filename": filename}) == "" {
+		return fmt.Errorf("gomail: invalid filename: %q", filename)
+	}
+	p, err := msg.bodyPart(contentType)
+	if err != nil {
+		return err
+	}
+	p.filename = filename
+
+	return nil
+}
+
+// bodyPart returns the body having the given content type.
+func (msg *Message) bodyPart(contentType string) (*part, error) {
+	for i := range msg.parts {
+		if msg.parts[i].contentType == contentType {
+			return &msg.parts[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("gomail: no body with content type %q", contentType)
+}
+
+const (
+	// AMPHTML is the content type of AMP for Email bodies.
+	AMPHTML = "text/x-amp-html"
+	// WatchHTML is the content type of the bodies displayed by Apple Watch.
+	WatchHTML = "text/watch-html"
+)
+
+// AddAMPAlternative adds an AMP for Email alternative body to the message. The
+// parts are ordered when the message is exported so that the AMP body comes
+// after the plain text body and before the HTML body.
+func (msg *Message) AddAMPAlternative(body string) {
+	msg.AddAlternative(AMPHTML, body)
+}
+
+// AddWatchAlternative adds an alternative body displayed by Apple Watch to the
+// message. It comes after the plain text body and before the HTML body.
+func (msg *Message) AddWatchAlternative(body string) {
+	msg.AddAlternative(WatchHTML, body)
+}
+
+// SetBodyReader sets the body of the message to the content read from r. If
+// reading from r returns an error, the body of the message is left unchanged.
+func (msg *Message) SetBodyReader(contentType string, r io.Reader) error {
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return err
+	}
+	msg.parts = []part{{contentType: contentType, body: buf}}
+
+	return nil
+}
+
+// A TemplateExecutor is a template that can be executed, like the templates of
+// the packages text/template and html/template.
+type TemplateExecutor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// SetBodyTemplate sets the body of the message to the result of executing the
+// template tmpl with the given data. If the template returns an error, the body
+// of the message is left unchanged.
+func (msg *Message) SetBodyTemplate(contentType string, tmpl TemplateExecutor, data interface{}) error {
+	buf, err := executeTemplate(tmpl, data)
+	if err != nil {
+		return err
+	}
+	msg.parts = []part{{contentType: contentType, body: buf}}
+
+	return nil
+}
+
+// AddAlternativeTemplate adds an alternative body to the message using the
+// result of executing the template tmpl with the given data. If the template
+// returns an error, no body is added.
+func (msg *Message) AddAlternativeTemplate(contentType string, tmpl TemplateExecutor, data interface{}) error {
+	buf, err := executeTemplate(tmpl, data)
+	if err != nil {
+		return err
+	}
+	msg.parts = append(msg.parts, part{contentType: contentType, body: buf})
+
+	return nil
+}
+
+func executeTemplate(tmpl TemplateExecutor, data interface{}) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
 }
 
 // GetBodyWriter gets a writer that writes to the body. It can be useful with
-// the templates from packages text/template or html/template.
+// the templates from packages text/template or html/template, although
+// AddAlternativeTemplate should be preferred since a template returning an
+// error leaves a partial body in the message.
 //
 // Example:
 //
@@ -174,24 +910,137 @@ func (msg *Message) AddAlternative(contentType, body string) {
 //	t.Execute(w, "Bob")
 func (msg *Message) GetBodyWriter(contentType string) io.Writer {
 	buf := new(bytes.Buffer)
-	msg.parts = append(msg.parts, part{contentType, buf})
+	msg.parts = append(msg.parts, part{contentType: contentType, body: buf})
 
 	return buf
 }
 
-// Attach attaches a file to the message.
+// Attach attaches a file to the message. It returns an error if the file does
+// not exist or is not a regular file. The file is only read when the message
+// is exported, so it must not be removed before.
 func (msg *Message) Attach(filename string) error {
-	content, err := readFile(filename)
+	return msg.AttachWithOptions(filename, AttachOptions{})
+}
+
+// SetBinaryAttachments sets whether the attached and embedded files encoded in
+// base64 are sent unencoded, using the binary Content-Transfer-Encoding, when
+// the message is sent by Mailer.Send to an SMTP server supporting the CHUNKING
+// and BINARYMIME extensions (see RFC 3030). It avoids the overhead of base64
+// for large files. The files are still encoded in base64 if the server does
+// not support these extensions and by Export. It is disabled by default.
+func (msg *Message) SetBinaryAttachments(binary bool) {
+	msg.binaryFiles = binary
+}
+
+// AttachOptions are the options used to attach a file to a message.
+type AttachOptions struct {
+	// Encoding is the Content-Transfer-Encoding of the attachment. It can be
+	// Base64, QuotedPrintable or SevenBit. Base64 is used if it is empty.
+	Encoding string
+	// CreationDate and ModificationDate are added to the Content-Disposition
+	// field of the attachment as defined in RFC 2183 unless they are zero.
+	CreationDate     time.Time
+	ModificationDate time.Time
+	// Charset is the charset parameter of the Content-Type field of a text
+	// attachment. It replaces the charset associated with the file extension,
+	// if any, and must be empty if the attachment is not a text file.
+	Charset string
+}
+
+// AttachWithOptions attaches a file to the message using the given options.
+func (msg *Message) AttachWithOptions(filename string, opts AttachOptions) error {
+	switch opts.Encoding {
+	case "":
+		opts.Encoding = Base64
+	case Base64, QuotedPrintable, SevenBit:
+	default:
+		return fmt.Errorf("gomail: unsupported attachment encoding: %q", opts.Encoding)
+	}
+
+	if msg.maxAttachments > 0 && len(msg.attachments) >= msg.maxAttachments {
+		return fmt.Errorf("gomail: cannot attach %q: the message already has %d attachments", filename, len(msg.attachments))
+	}
+	name := filepath.Base(filename)
+	if opts.Charset != "" {
+		if !isValidCharset(opts.Charset) {
+			return fmt.Errorf("gomail: invalid charset: %q", opts.Charset)
+		}
+		if !strings.HasPrefix(msg.attachmentType(name), "text/") {
+			return fmt.Errorf("gomail: cannot set the charset of %q: not a text file", filename)
+		}
+	}
+	if err := msg.checkFile(filename); err != nil {
+		return err
+	}
+
+	msg.attachments = append(msg.attachments, attachment{
+		name:             name,
+		filename:         filename,
+		encoding:         opts.Encoding,
+		creationDate:     opts.CreationDate,
+		modificationDate: opts.ModificationDate,
+		charset:          opts.Charset,
+	})
+
+	return nil
+}
+
+// EmbedImage embeds an image in the message so that it can be displayed in the
+// HTML body. It returns the Content-ID of the image, which is unique, to be
+// referenced in the HTML body using a cid URL:
+//
+//	cid, err := msg.EmbedImage("/home/Alex/lolcat.jpg")
+//	if err != nil {
+//		return err
+//	}
+//	msg.SetBody("text/html", `<img src="cid:`+cid+`">`)
+func (msg *Message) EmbedImage(filename string) (cid string, err error) {
+	if err := msg.checkFile(filename); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	cid = fmt.Sprintf("%d.%x@gomail", len(msg.embedded)+1, b)
+	msg.embedded = append(msg.embedded, attachment{
+		name:     filepath.Base(filename),
+		filename: filename,
+		encoding: Base64,
+		cid:      cid,
+	})
+
+	return cid, nil
+}
+
+// checkFile returns an error if filename is not a regular file or if it is
+// empty and SetRejectEmptyFiles is enabled.
+func (msg *Message) checkFile(filename string) error {
+	fi, err := stat(filename)
 	if err != nil {
 		return err
 	}
-	msg.attachments = append(msg.attachments, attachment{filepath.Base(filename), content})
+	if fi.IsDir() {
+		return fmt.Errorf("gomail: cannot attach %q: is a directory", filename)
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("gomail: cannot attach %q: not a regular file", filename)
+	}
+	if msg.rejectEmpty && fi.Size() == 0 {
+		return fmt.Errorf("gomail: cannot attach %q: empty file", filename)
+	}
 
 	return nil
 }
 
 // Stubbed out for testing.
-var readFile = ioutil.ReadFile
+var (
+	stat     = os.Stat
+	readFile = func(filename string) (io.ReadCloser, error) {
+		return os.Open(filename)
+	}
+)
 
 // A Mailer represents an SMTP server.
 type Mailer struct {
@@ -209,12 +1058,62 @@ func NewCustomMailer(auth smtp.Auth, addr string) Mailer {
 	return Mailer{m: mailer.NewCustomMailer(auth, addr)}
 }
 
+// SetDialer sets the function used to connect to the SMTP server. It can be
+// used to send emails through a proxy or to bind a specific local address.
+func (m Mailer) SetDialer(dial mailer.DialFunc) {
+	m.m.SetDialer(dial)
+}
+
+// SetLogger sets a function called each time an email is sent to the SMTP
+// server.
+func (m Mailer) SetLogger(f func(mailer.SendEvent)) {
+	m.m.SetLogger(f)
+}
+
+// SetOmitBcc sets whether the Bcc field is removed from the emails sent.
+func (m Mailer) SetOmitBcc(omit bool) {
+	m.m.SetOmitBcc(omit)
+}
+
+// SetRefreshDate sets whether the Date field of the emails is set to the time
+// they are sent, see mailer.Mailer.SetRefreshDate.
+func (m Mailer) SetRefreshDate(refresh bool) {
+	m.m.SetRefreshDate(refresh)
+}
+
 // Send sends the emails to the recipients of the message.
 func (m Mailer) Send(message *Message) error {
+	if message.binaryFiles {
+		return m.sendBinary(message)
+	}
 	msg, err := message.Export()
 	if err != nil {
-		return nil
+		return err
 	}
 
 	return m.m.Send(msg)
 }
+
+// sendBinary sends the message with its files unencoded if the SMTP server
+// supports it.
+func (m Mailer) sendBinary(message *Message) error {
+	s, err := m.m.Dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if !s.Extension("CHUNKING") || !s.Extension("BINARYMIME") {
+		msg, err := message.Export()
+		if err != nil {
+			return err
+		}
+		return s.Send(msg)
+	}
+	msg, err := message.export(true, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.SendBinary(msg)
+}
@@ -1,16 +1,30 @@
 package gomail
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/mail"
+	"net/textproto"
+	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"text/template"
 	"time"
+
+	"github.com/alexcesaro/mail/quotedprintable"
 )
 
 func TestMessage(t *testing.T) {
@@ -41,6 +55,346 @@ func TestMessage(t *testing.T) {
 	testMessage(t, msg, header, "=C2=A1Hola, se=C3=B1or!")
 }
 
+func TestAddressHeader(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"John Doe", "John Doe <a@example.com>"},
+		{"Doe, John", `"Doe, John" <a@example.com>`},
+		{"O'Brien", "O'Brien <a@example.com>"},
+		{"J. R. \"Bob\" Dobbs", `"J. R. \"Bob\" Dobbs" <a@example.com>`},
+		{"=?UTF-8?Q?a?=", `"=?UTF-8?Q?a?=" <a@example.com>`},
+		{"Doe, Jöhn", "=?UTF-8?Q?Doe=2C_J=C3=B6hn?= <a@example.com>"},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetAddressHeader("From", "a@example.com", test.name)
+		got := msg.GetHeader("From")[0]
+		if got != test.want {
+			t.Errorf("Invalid From field for %q, got %q, want %q", test.name, got, test.want)
+		}
+		addr, err := mail.ParseAddress(got)
+		if err != nil {
+			t.Errorf("mail.ParseAddress(%q) returned error %v", got, err)
+		} else if addr.Name != test.name {
+			t.Errorf("mail.ParseAddress(%q) returned name %q, want %q", got, addr.Name, test.name)
+		}
+	}
+}
+
+func TestRawHeader(t *testing.T) {
+	msg := NewMessage()
+	msg.SetRawHeader("Subject", "=?UTF-8?Q?=C2=A1Hola,_se=C3=B1or!?=")
+	msg.AddRawHeader("X-Raw", "café")
+	msg.AddRawHeader("X-Raw", "=?UTF-8?Q?caf=C3=A9?=")
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Subject":      {"=?UTF-8?Q?=C2=A1Hola,_se=C3=B1or!?="},
+		"X-Raw":        {"café", "=?UTF-8?Q?caf=C3=A9?="},
+	}
+
+	testMessage(t, msg, header, "")
+}
+
+func TestOrganization(t *testing.T) {
+	msg := NewMessage()
+	msg.SetOrganization("Société Générale")
+	msg.SetMailerName("gomail")
+	msg.SetBody("text/plain", "Test")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Organization":              {"=?UTF-8?Q?Soci=C3=A9t=C3=A9_G=C3=A9n=C3=A9rale?="},
+		"User-Agent":                {"gomail"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "Test")
+}
+
+func TestDelHeaderValue(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("To", "a@example.com")
+	msg.AddAddressHeader("To", "b@example.com", "Señor B")
+	msg.AddHeader("To", "c@example.com")
+
+	if !msg.DelHeaderValue("To", "Señor B <b@example.com>") {
+		t.Error("DelHeaderValue should return true when a value is deleted")
+	}
+	if got, want := msg.GetHeader("To"), []string{"a@example.com", "c@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid To field, got %q, want %q", got, want)
+	}
+	if msg.DelHeaderValue("To", "b@example.com") {
+		t.Error("DelHeaderValue should return false when no value is deleted")
+	}
+
+	msg.DelHeaderValue("To", "a@example.com")
+	msg.DelHeaderValue("To", "c@example.com")
+	if _, ok := msg.header["To"]; ok {
+		t.Error("The field should be deleted when it has no value left")
+	}
+}
+
+func TestAutoSubmitted(t *testing.T) {
+	msg := NewMessage()
+	if err := msg.SetAutoSubmitted("auto-generated"); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetBody("text/plain", "Test")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Auto-Submitted":            {"auto-generated"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "Test")
+
+	if err := msg.SetAutoSubmitted("auto-notified"); err == nil {
+		t.Error("SetAutoSubmitted should return an error for an invalid value")
+	}
+}
+
+func TestListHeaders(t *testing.T) {
+	msg := NewMessage()
+	err := msg.SetListHeaders(ListOptions{
+		ID:          "amis.example.com",
+		Description: "Les Amis de l'Été",
+		Post:        []string{"mailto:amis@example.com"},
+		Archive:     []string{"https://example.com/amis/archive"},
+		Help:        []string{"mailto:amis-help@example.com", "https://example.com/amis/help"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.SetBody("text/plain", "Test")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"List-Id":                   {"=?UTF-8?Q?Les_Amis_de_l=27=C3=89t=C3=A9?= <amis.example.com>"},
+		"List-Post":                 {"<mailto:amis@example.com>"},
+		"List-Archive":              {"<https://example.com/amis/archive>"},
+		"List-Help":                 {"<mailto:amis-help@example.com>, <https://example.com/amis/help>"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "Test")
+
+	msg = NewMessage()
+	if err := msg.SetListHeaders(ListOptions{ID: "amis.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msg.GetHeader("List-Id"), []string{"<amis.example.com>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid List-Id field, got %q, want %q", got, want)
+	}
+	if _, ok := msg.header["List-Post"]; ok {
+		t.Error("List-Post should not be set without URL")
+	}
+	if err := msg.SetListHeaders(ListOptions{ID: "amis example"}); err == nil {
+		t.Error("SetListHeaders should return an error for an invalid identifier")
+	}
+
+	err = msg.SetListHeaders(ListOptions{
+		ID:      "other.example.com",
+		Post:    []string{"mailto:other@example.com"},
+		Archive: []string{"https://example.com/other archive"},
+	})
+	if err == nil {
+		t.Error("SetListHeaders should return an error for an invalid URL")
+	}
+	want := map[string][]string{"List-Id": {"<amis.example.com>"}}
+	for _, field := range []string{"List-Id", "List-Post", "List-Archive"} {
+		if got := msg.GetHeader(field); !reflect.DeepEqual(got, want[field]) {
+			t.Errorf("%s should be unchanged after an error, got %q, want %q", field, got, want[field])
+		}
+	}
+}
+
+func TestReplaceHeaders(t *testing.T) {
+	now = stubNow
+	msg := NewMessage()
+	msg.SetHeader("X-Removed", "removed")
+	msg.ReplaceHeaders([]string{"Subject", "From", "To", "X-Absent"}, map[string][]string{
+		"To":       {"a@example.com", "b@example.com"},
+		"From":     {"=?UTF-8?Q?Se=C3=B1or?= <from@example.com>"},
+		"Subject":  {"=?UTF-8?Q?=C2=A1Hola!?="},
+		"X-Mailer": {"gomail"},
+	})
+	msg.SetBody("text/plain", "Test")
+
+	buf := new(bytes.Buffer)
+	n, err := msg.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, want %d", n, buf.Len())
+	}
+
+	want := "Subject: =?UTF-8?Q?=C2=A1Hola!?=\r\n" +
+		"From: =?UTF-8?Q?Se=C3=B1or?= <from@example.com>\r\n" +
+		"To: a@example.com, b@example.com\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Date: 25 Jun 14 17:46 UTC\r\n" +
+		"Mime-Version: 1.0\r\n" +
+		"X-Mailer: gomail\r\n" +
+		"\r\n" +
+		"Test"
+	if got := buf.String(); got != want {
+		t.Errorf("Invalid message, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSubjectTruncated(t *testing.T) {
+	tests := []struct {
+		subject  string
+		maxRunes int
+		want     string
+	}{
+		{"señor señor", 4, "=?UTF-8?Q?se=C3=B1=E2=80=A6?="},
+		{"señor", 5, "=?UTF-8?Q?se=C3=B1or?="},
+		{"Hello!", 3, "=?UTF-8?Q?He=E2=80=A6?="},
+		{"Hello!", 6, "Hello!"},
+		{"Hello!", 0, ""},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetSubjectTruncated(test.subject, test.maxRunes)
+		if got := msg.GetHeader("Subject")[0]; got != test.want {
+			t.Errorf("SetSubjectTruncated(%q, %d) set %q, want %q", test.subject, test.maxRunes, got, test.want)
+		}
+	}
+}
+
+func TestSingleWordSubject(t *testing.T) {
+	subject := strings.Repeat("é", 40)
+
+	msg := NewMessage()
+	msg.SetHeader("Subject", subject)
+	if got := msg.GetHeader("Subject")[0]; !strings.Contains(got, "\r\n") {
+		t.Errorf("Subject should be folded by default, got %q", got)
+	}
+
+	msg.SetSingleWordSubject(true)
+	msg.SetHeader("Subject", subject)
+	want := "=?UTF-8?Q?" + strings.Repeat("=C3=A9", 40) + "?="
+	if got := msg.GetHeader("Subject")[0]; got != want {
+		t.Errorf("Invalid Subject field, got %q, want %q", got, want)
+	}
+}
+
+func TestTrimHeaderValues(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("Subject", "  Hello  ")
+	if got := msg.GetHeader("Subject")[0]; got != "  Hello  " {
+		t.Errorf("The header values should not be trimmed by default, got %q", got)
+	}
+
+	msg.SetTrimHeaderValues(true)
+	msg.SetHeader("Subject", " \t Hello   world  ")
+	msg.AddHeader("X-Tag", "  Señor  ")
+	if got, want := msg.GetHeader("Subject")[0], "Hello   world"; got != want {
+		t.Errorf("Invalid Subject field, got %q, want %q", got, want)
+	}
+	if got, want := msg.GetHeader("X-Tag")[0], "=?UTF-8?Q?Se=C3=B1or?="; got != want {
+		t.Errorf("Invalid X-Tag field, got %q, want %q", got, want)
+	}
+}
+
+func TestReturnPath(t *testing.T) {
+	msg := NewMessage()
+	if err := msg.SetReturnPath("Bounces <bounces@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.ReturnPath(); got != "bounces@example.com" {
+		t.Errorf("ReturnPath() = %q, want %q", got, "bounces@example.com")
+	}
+	if got := msg.GetHeader("Return-Path"); len(got) != 1 || got[0] != "<bounces@example.com>" {
+		t.Errorf("Invalid Return-Path field, got %q", got)
+	}
+
+	if err := msg.SetReturnPath("bounces"); err == nil {
+		t.Error("SetReturnPath should return an error for an invalid address")
+	}
+	if got := msg.ReturnPath(); got != "bounces@example.com" {
+		t.Errorf("ReturnPath() = %q after an invalid address, want %q", got, "bounces@example.com")
+	}
+}
+
+func TestSetNow(t *testing.T) {
+	now = stubNow
+	fixed := time.Date(2001, 02, 03, 04, 05, 0, 0, time.UTC)
+
+	msgs := []*Message{NewMessage(), NewMessage()}
+	msgs[0].SetNow(func() time.Time { return fixed })
+	want := []string{buildDateHeader(fixed), buildDateHeader(stubNow())}
+
+	got := make([]string, len(msgs))
+	done := make(chan bool)
+	for i, msg := range msgs {
+		go func(i int, msg *Message) {
+			defer func() { done <- true }()
+			m, err := msg.Export()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got[i] = m.Header.Get("Date")
+		}(i, msg)
+	}
+	for range msgs {
+		<-done
+	}
+
+	for i := range msgs {
+		if got[i] != want[i] {
+			t.Errorf("Invalid Date field of message #%d, got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRequestReadReceipt(t *testing.T) {
+	tests := []struct {
+		address, want string
+	}{
+		{"alex@example.com", "alex@example.com"},
+		{"<alex@example.com>", "alex@example.com"},
+		{"Alex <alex@example.com>", "Alex <alex@example.com>"},
+		{"=?UTF-8?Q?Se=C3=B1or?= <alex@example.com>", "=?UTF-8?Q?Se=C3=B1or?= <alex@example.com>"},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		if err := msg.RequestReadReceipt(test.address); err != nil {
+			t.Errorf("RequestReadReceipt(%q) returned error %v", test.address, err)
+			continue
+		}
+		got := msg.GetHeader("Disposition-Notification-To")
+		if len(got) != 1 || got[0] != test.want {
+			t.Errorf("Invalid Disposition-Notification-To field for %q, got %q, want %q", test.address, got, test.want)
+		}
+	}
+
+	msg := NewMessage()
+	if err := msg.RequestReadReceipt("alex"); err == nil {
+		t.Error("RequestReadReceipt should return an error for an invalid address")
+	}
+	if got := msg.GetHeader("Disposition-Notification-To"); got != nil {
+		t.Errorf("The field should not be set after an error, got %q", got)
+	}
+}
+
 func TestCustomMessage(t *testing.T) {
 	msg := NewCustomMessage("ISO-8859-1", Base64)
 	msg.AddHeader("Subject", "café")
@@ -57,6 +411,132 @@ func TestCustomMessage(t *testing.T) {
 	testMessage(t, msg, header, "wqFIb2xhLCBzZcOxb3Ih")
 }
 
+func TestSetEncoding(t *testing.T) {
+	msg := NewMessage()
+	if err := msg.SetEncoding(Base64); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetHeader("Subject", "café")
+	msg.SetBody("text/plain", "¡Hola, señor!")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Subject":                   {"=?UTF-8?B?Y2Fmw6k=?="},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+
+	testMessage(t, msg, header, "wqFIb2xhLCBzZcOxb3Ih")
+
+	if err := msg.SetEncoding("8bit"); err == nil {
+		t.Error("SetEncoding should return an error when the encoding is not supported")
+	}
+}
+
+func TestSetHeaderEncoding(t *testing.T) {
+	msg := NewMessage()
+	if err := msg.SetHeaderEncoding(quotedprintable.B); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetHeader("Subject", "¡Hola, señor!")
+	msg.SetBody("text/plain", "¡Hola, señor!")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Subject":                   {"=?UTF-8?B?wqFIb2xhLCBzZcOxb3Ih?="},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	}
+
+	testMessage(t, msg, header, "=C2=A1Hola, se=C3=B1or!")
+
+	if err := msg.SetHeaderEncoding("A"); err == nil {
+		t.Error("SetHeaderEncoding should return an error when the encoding is not supported")
+	}
+}
+
+func TestSetCharset(t *testing.T) {
+	msg := NewMessage()
+	if err := msg.SetCharset("ISO-8859-1"); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetHeader("Subject", "caf\xe9")
+	msg.SetBody("text/plain", "caf\xe9")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Subject":                   {"=?ISO-8859-1?Q?caf=E9?="},
+		"Content-Type":              {"text/plain; charset=ISO-8859-1"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	}
+
+	testMessage(t, msg, header, "caf=E9")
+
+	for _, charset := range []string{"", "UTF 8", "UTF-8; format=flowed"} {
+		if err := msg.SetCharset(charset); err == nil {
+			t.Errorf("SetCharset(%q) should return an error", charset)
+		}
+	}
+}
+
+func TestSevenBitBody(t *testing.T) {
+	tests := []struct {
+		body, encoding string
+		normalize      bool
+	}{
+		{"Hello,\nhow are you?", "7bit", true},
+		{"Hello,\nhow are you?", "quoted-printable", false},
+		{"Hello,\r\nhow are you?", "7bit", false},
+		{"¡Hola, señor!", "quoted-printable", true},
+		{strings.Repeat("0", 999), "quoted-printable", true},
+		{"a\x00b", "quoted-printable", true},
+	}
+
+	for _, test := range tests {
+		msg := NewMessage()
+		msg.SetNormalizeNewlines(test.normalize)
+		msg.SetBody("text/plain", test.body)
+		m, err := msg.Export()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Header.Get("Content-Transfer-Encoding"); got != test.encoding {
+			t.Errorf("Invalid Content-Transfer-Encoding of %q, got %q, want %q", test.body, got, test.encoding)
+		}
+	}
+}
+
+func TestContentTypeParameters(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain; format=flowed", "Test")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; format=flowed; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "Test")
+}
+
+func TestContentTypeCharset(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain; charset=US-ASCII; format=flowed", "Test")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=US-ASCII; format=flowed"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "Test")
+}
+
 func TestEmpty(t *testing.T) {
 	msg := NewMessage()
 
@@ -95,12 +575,40 @@ func TestAlternative(t *testing.T) {
 	testMessage(t, msg, header, body)
 }
 
-func TestAttachment(t *testing.T) {
-	readFile = stubReadFile
+func TestBodies(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Replaced")
+	msg.SetBodies("Hello", "<b>Hello</b>")
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/alternative; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<b>Hello</b>\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
 
+func TestBodyMultipartType(t *testing.T) {
 	msg := NewMessage()
-	msg.SetBody("text/plain", "Test")
-	msg.Attach("/tmp/test.pdf")
+	if err := msg.SetBodyMultipartType("mixed"); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetBody("text/html", "<b>Hello</b>")
+	msg.AddAlternative("text/plain", "Bye")
 
 	boundary := getMainBoundary(t, msg)
 
@@ -110,114 +618,1141 @@ func TestAttachment(t *testing.T) {
 		"Content-Type": {"multipart/mixed; boundary=" + boundary},
 	}
 	body := "--" + boundary + "\r\n" +
-		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
 		"Content-Transfer-Encoding: quoted-printable\r\n" +
 		"\r\n" +
-		"Test\r\n" +
+		"<b>Hello</b>\r\n" +
 		"--" + boundary + "\r\n" +
-		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
-		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
-		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
 		"\r\n" +
-		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"Bye\r\n" +
 		"--" + boundary + "--\r\n"
 
-	testMessage(t, msg, header, body)
+	testMessage(t, msg, header, body)
+
+	if err := msg.SetBodyMultipartType("digest"); err == nil {
+		t.Error("SetBodyMultipartType should return an error for an invalid subtype")
+	}
+}
+
+func TestPartFieldOrder(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	msg.SetBoundaryFunc(func() string { return "boundary" })
+	msg.SetPartHeaderFunc(func(kind PartKind, h textproto.MIMEHeader) {
+		if kind == AttachmentPart {
+			h.Set("X-Attachment-Id", "1")
+			h.Set("Content-Description", "Test")
+			h.Set("Content-ID", "<test@example.com>")
+		}
+	})
+	msg.SetBody("text/plain", "Test")
+	msg.Attach("/tmp/test.pdf")
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "--boundary\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Test\r\n" +
+		"--boundary\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"Content-Id: <test@example.com>\r\n" +
+		"Content-Description: Test\r\n" +
+		"X-Attachment-Id: 1\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"--boundary--\r\n"
+	if got := string(b); got != want {
+		t.Errorf("Invalid body, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBodyCharset(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain; charset=UTF-8; format=flowed", "Café")
+	if err := msg.SetBodyCharset("text/plain; charset=UTF-8; format=flowed", "windows-1252"); err != nil {
+		t.Fatal(err)
+	}
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=windows-1252; format=flowed"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	}
+
+	testMessage(t, msg, header, "Caf=C3=A9")
+
+	if err := msg.SetBodyCharset("text/html", "windows-1252"); err == nil {
+		t.Error("SetBodyCharset should return an error when there is no such body")
+	}
+	if err := msg.SetBodyCharset("text/plain", "windows 1252"); err == nil {
+		t.Error("SetBodyCharset should return an error for an invalid charset")
+	}
+}
+
+func TestPartEncoding(t *testing.T) {
+	msg := NewCustomMessage("UTF-8", Base64)
+	msg.SetBody("text/plain", "¡Hola, señor!")
+	msg.AddAlternative("text/html", "<b>¡Hola, señor!</b>")
+	if err := msg.SetBodyEncoding("text/plain", QuotedPrintable); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.SetBodyEncoding("text/calendar", QuotedPrintable); err == nil {
+		t.Error("SetBodyEncoding should return an error when the message has no such body")
+	}
+	if err := msg.SetBodyEncoding("text/html", "8bit"); err == nil {
+		t.Error("SetBodyEncoding should return an error when the encoding is not supported")
+	}
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = WalkParts(m, func(h textproto.MIMEHeader, body io.Reader) error {
+		b, err := ioutil.ReadAll(body)
+		got = append(got, h.Get("Content-Transfer-Encoding")+": "+string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"quoted-printable: ¡Hola, señor!", "base64: <b>¡Hola, señor!</b>"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid parts, got %q, want %q", got, want)
+	}
+}
+
+func TestBoundaryFunc(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	n := 0
+	msg.SetBoundaryFunc(func() string {
+		n++
+		return fmt.Sprintf("boundary %d", n)
+	})
+	msg.SetBody("text/plain", "Test")
+	msg.AddAlternative("text/html", "<b>Test</b>")
+	msg.Attach("/tmp/test.pdf")
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=\"boundary 1\""},
+	}
+	body := "--boundary 1\r\n" +
+		"Content-Type: multipart/alternative; boundary=\"boundary 2\"\r\n" +
+		"\r\n" +
+		"--boundary 2\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Test\r\n" +
+		"--boundary 2\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<b>Test</b>\r\n" +
+		"--boundary 2--\r\n" +
+		"\r\n" +
+		"--boundary 1\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"--boundary 1--\r\n"
+
+	testMessage(t, msg, header, body)
+
+	msg.SetBoundaryFunc(func() string { return "" })
+	if _, err := msg.Export(); err == nil {
+		t.Error("Export should return an error when a boundary is invalid")
+	}
+}
+
+func TestAlternativeOrder(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/html", "<b>Hello</b>")
+	msg.AddAlternative("text/plain", "Hello")
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/alternative; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<b>Hello</b>\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestBodyDescription(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Hello")
+	msg.AddAlternative("text/html", "<b>Hello</b>")
+	if err := msg.SetBodyDescription("text/html", "Version enrichie"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.SetBodyDescription("text/x-amp-html", "AMP"); err == nil {
+		t.Error("SetBodyDescription should return an error when there is no such body")
+	}
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/alternative; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Description: Version enrichie\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<b>Hello</b>\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestAMPAlternative(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/html; charset=UTF-8", "<b>Hello</b>")
+	msg.AddAMPAlternative("<html ⚡4email>Hello</html>")
+	msg.AddAlternative("text/plain", "Hello")
+	msg.AddWatchAlternative("<p>Hello</p>")
+
+	var got []string
+	for _, p := range msg.alternatives() {
+		got = append(got, p.contentType)
+	}
+
+	want := []string{"text/plain", "text/watch-html", "text/x-amp-html", "text/html; charset=UTF-8"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid order of the alternatives, got %q, want %q", got, want)
+	}
+}
+
+func TestAttachment(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	msg.Attach("/tmp/test.pdf")
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Test\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestAttachmentOnly(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	msg.Attach("/tmp/test.pdf")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"application/pdf; name=\"test.pdf\""},
+		"Content-Disposition":       {"attachment; filename=\"test.pdf\""},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	body := base64.StdEncoding.EncodeToString([]byte("Content of test.pdf"))
+
+	testMessage(t, msg, header, body)
+}
+
+func TestWrapSingleAttachment(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	msg.SetWrapSingleAttachment(true)
+	msg.Attach("/tmp/test.pdf")
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestWrapSingleAttachmentWithBody(t *testing.T) {
+	msg := NewMessage()
+	msg.SetWrapSingleAttachment(true)
+	msg.SetBody("text/plain", "Test")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "Test")
+}
+
+func TestEmbedImage(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	cid1, err := msg.EmbedImage("/tmp/image1.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid2, err := msg.EmbedImage("/tmp/image2.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid1 == cid2 {
+		t.Fatalf("The Content-IDs should be unique, got %q twice", cid1)
+	}
+	msg.SetBody("text/plain", "Images")
+	msg.AddAlternative("text/html", `<img src="cid:`+cid1+`"><img src="cid:`+cid2+`">`)
+	msg.Attach("/tmp/test.pdf")
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = walkStructure(textproto.MIMEHeader(m.Header), m.Body, func(h textproto.MIMEHeader) {
+		part := h.Get("Content-Type")
+		if cid := h.Get("Content-ID"); cid != "" {
+			part += " " + cid
+		}
+		got = append(got, part)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"multipart/mixed",
+		"multipart/related; type=\"multipart/alternative\"",
+		"multipart/alternative",
+		"text/plain; charset=UTF-8",
+		"text/html; charset=UTF-8",
+		"image/jpeg; name=\"image1.jpg\" <" + cid1 + ">",
+		"image/jpeg; name=\"image2.jpg\" <" + cid2 + ">",
+		"application/pdf; name=\"test.pdf\"",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid structure, got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestBodyContentID(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Hello")
+	msg.AddAlternative("text/html", "<b>Hello</b>")
+	if err := msg.SetBodyContentID("text/html", "html@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = walkStructure(textproto.MIMEHeader(m.Header), m.Body, func(h textproto.MIMEHeader) {
+		got = append(got, h.Get("Content-Type")+" "+h.Get("Content-ID"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"multipart/alternative ",
+		"text/plain; charset=UTF-8 ",
+		"text/html; charset=UTF-8 <html@example.com>",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid structure, got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, cid := range []string{"", "<html@example.com>", "html @example.com"} {
+		if err := msg.SetBodyContentID("text/html", cid); err == nil {
+			t.Errorf("SetBodyContentID(%q) should return an error", cid)
+		}
+	}
+	if err := msg.SetBodyContentID("text/calendar", "cal@example.com"); err == nil {
+		t.Error("SetBodyContentID should return an error when there is no such body")
+	}
+}
+
+func TestBodyFilename(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Hello")
+	msg.AddAlternative("text/html", "<b>Hello</b>")
+	if err := msg.SetBodyFilename("text/html", "preview.html"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.SetBodyFilename("text/plain", "aperçu.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = walkStructure(textproto.MIMEHeader(m.Header), m.Body, func(h textproto.MIMEHeader) {
+		got = append(got, h.Get("Content-Type")+" "+h.Get("Content-Disposition"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"multipart/alternative ",
+		"text/plain; charset=UTF-8 inline; filename*=utf-8''aper%C3%A7u.txt",
+		"text/html; charset=UTF-8 inline; filename=preview.html",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid structure, got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err := msg.SetBodyFilename("text/html", ""); err == nil {
+		t.Error("SetBodyFilename should return an error for an empty filename")
+	}
+	if err := msg.SetBodyFilename("text/calendar", "invite.ics"); err == nil {
+		t.Error("SetBodyFilename should return an error when there is no such body")
+	}
+}
+
+// walkStructure calls fn with the header of each part of a message, the
+// boundary parameters of the multipart parts being removed.
+func walkStructure(h textproto.MIMEHeader, body io.Reader, fn func(textproto.MIMEHeader)) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		fn(h)
+		return nil
+	}
+
+	boundary := params["boundary"]
+	delete(params, "boundary")
+	h = textproto.MIMEHeader{"Content-Type": {mime.FormatMediaType(mediaType, params)}}
+	fn(h)
+	r := multipart.NewReader(body, boundary)
+	for {
+		p, err := r.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := walkStructure(p.Header, p, fn); err != nil {
+			return err
+		}
+	}
+}
+
+func TestAttachmentLazyRead(t *testing.T) {
+	stat = stubStat
+	var read []string
+	readFile = func(filename string) (io.ReadCloser, error) {
+		read = append(read, filename)
+		return stubReadFile(filename)
+	}
+	defer func() {
+		readFile = stubReadFile
+	}()
+
+	msg := NewMessage()
+	if err := msg.Attach("/tmp/test.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 0 {
+		t.Fatalf("Attach should not read the file, got %d reads", len(read))
+	}
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"application/pdf; name=\"test.pdf\""},
+		"Content-Disposition":       {"attachment; filename=\"test.pdf\""},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	body := base64.StdEncoding.EncodeToString([]byte("Content of test.pdf"))
+
+	testMessage(t, msg, header, body)
+
+	if got := strings.Join(read, ", "); got != "/tmp/test.pdf" {
+		t.Errorf("Export should read the file once, got reads: %q", got)
+	}
+}
+
+func TestAttachmentReadError(t *testing.T) {
+	stat = stubStat
+	readFile = func(filename string) (io.ReadCloser, error) {
+		return nil, errors.New("read error")
+	}
+	defer func() {
+		readFile = stubReadFile
+	}()
+
+	msg := NewMessage()
+	msg.Attach("/tmp/test.pdf")
+	if _, err := msg.Export(); err == nil {
+		t.Error("Export should return an error when the file cannot be read")
+	}
+}
+
+func TestBinaryAttachments(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+	tests := []struct {
+		extensions []string
+		command    string
+		want       string
+	}{
+		{[]string{"CHUNKING", "BINARYMIME"}, "BDAT", "Content-Transfer-Encoding: binary\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"\r\n" +
+			"Content of test.pdf\r\n"},
+		{[]string{"8BITMIME"}, "DATA", "Content-Transfer-Encoding: base64\r\n" +
+			"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n"},
+	}
+
+	for _, test := range tests {
+		var commands []string
+		received := new(bytes.Buffer)
+		m := NewCustomMailer(nil, "host:25")
+		m.SetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go serveSMTP(server, received, &commands, test.extensions...)
+			return client, nil
+		})
+
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("To", "to@example.com")
+		msg.SetBody("text/plain", "Test")
+		if err := msg.Attach("/tmp/test.pdf"); err != nil {
+			t.Fatal(err)
+		}
+		msg.SetBinaryAttachments(true)
+		if err := m.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(commands[3], test.command) {
+			t.Errorf("The email should be sent with the %s command, got commands %q", test.command, commands)
+		}
+		if !strings.Contains(received.String(), test.want) {
+			t.Errorf("Invalid attachment, got:\r\n%s\r\nwant:\r\n%s", received.String(), test.want)
+		}
+	}
+}
+
+func TestAttachmentEncoding(t *testing.T) {
+	stat = stubStat
+	readFile = func(filename string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("Caf\xe9 au lait\r\n")), nil
+	}
+	defer func() {
+		readFile = stubReadFile
+	}()
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	if err := msg.AttachWithOptions("/tmp/test.txt", AttachOptions{Encoding: QuotedPrintable}); err != nil {
+		t.Fatal(err)
+	}
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Test\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8; name=\"test.txt\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.txt\"\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Caf=E9 au lait\r\n" +
+		"\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestAttachmentCharset(t *testing.T) {
+	stat = stubStat
+	msg := NewMessage()
+	if err := msg.AttachWithOptions("/tmp/test.txt", AttachOptions{Charset: "ISO-8859-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=ISO-8859-1; name=\"test.txt\""},
+		"Content-Disposition":       {"attachment; filename=\"test.txt\""},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+
+	testMessage(t, msg, header, base64.StdEncoding.EncodeToString([]byte("Content of test.txt")))
+
+	if err := msg.AttachWithOptions("/tmp/test.pdf", AttachOptions{Charset: "ISO-8859-1"}); err == nil {
+		t.Error("AttachWithOptions should return an error when setting the charset of a non-text file")
+	}
+	if err := msg.AttachWithOptions("/tmp/test.txt", AttachOptions{Charset: "ISO 8859-1"}); err == nil {
+		t.Error("AttachWithOptions should return an error when the charset is invalid")
+	}
+}
+
+func TestAttachmentDates(t *testing.T) {
+	stat = stubStat
+	msg := NewMessage()
+	err := msg.AttachWithOptions("/tmp/test.pdf", AttachOptions{
+		CreationDate:     time.Date(2014, 06, 20, 9, 30, 0, 0, time.UTC),
+		ModificationDate: time.Date(2014, 06, 25, 17, 46, 12, 0, time.FixedZone("", -5*3600)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"application/pdf; name=\"test.pdf\""},
+		"Content-Disposition": {"attachment; filename=\"test.pdf\"; " +
+			"creation-date=\"Fri, 20 Jun 2014 09:30:00 +0000\"; " +
+			"modification-date=\"Wed, 25 Jun 2014 17:46:12 -0500\""},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+
+	testMessage(t, msg, header, base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")))
+}
+
+func TestMIMETypeResolver(t *testing.T) {
+	stat = stubStat
+	msg := NewMessage()
+	msg.SetMIMETypeResolver(func(filename string) string {
+		if filepath.Ext(filename) == ".log" {
+			return "text/plain"
+		}
+		return ""
+	})
+	if err := msg.Attach("/tmp/server.log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Attach("/tmp/test.pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = WalkParts(m, func(h textproto.MIMEHeader, body io.Reader) error {
+		got = append(got, h.Get("Content-Type"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`text/plain; name="server.log"`, `application/octet-stream; name="test.pdf"`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Invalid content types, got %q, want %q", got, want)
+	}
+}
+
+func TestAttachmentSevenBit(t *testing.T) {
+	stat = stubStat
+	readFile = func(filename string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("Caf\xe9")), nil
+	}
+	defer func() {
+		readFile = stubReadFile
+	}()
+
+	msg := NewMessage()
+	if err := msg.AttachWithOptions("/tmp/test.txt", AttachOptions{Encoding: SevenBit}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := msg.Export(); err == nil {
+		t.Error("Export should return an error when 8-bit data is sent using 7bit")
+	}
+
+	if err := msg.AttachWithOptions("/tmp/test.txt", AttachOptions{Encoding: "8bit"}); err == nil {
+		t.Error("AttachWithOptions should return an error when the encoding is not supported")
+	}
+}
+
+func TestAttachFileType(t *testing.T) {
+	stat = os.Stat
+	defer func() {
+		stat = stubStat
+	}()
+
+	dir, err := ioutil.TempDir("", "gomail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.txt")
+	if err := ioutil.WriteFile(filename, []byte("Test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := NewMessage()
+	if err := msg.Attach(filename); err != nil {
+		t.Errorf("Attach(%q) returned error: %v", filename, err)
+	}
+	if err := msg.Attach(dir); err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Errorf("Attach(%q) = %v, want a directory error", dir, err)
+	}
+	if err := msg.Attach(os.DevNull); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("Attach(%q) = %v, want a non-regular file error", os.DevNull, err)
+	}
+	if err := msg.Attach(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("Attach should return an error when the file does not exist")
+	}
+	if len(msg.attachments) != 1 {
+		t.Errorf("Only valid files should be attached, got %d attachments", len(msg.attachments))
+	}
+}
+
+func TestRejectEmptyFiles(t *testing.T) {
+	stat = os.Stat
+	defer func() {
+		stat = stubStat
+	}()
+
+	dir, err := ioutil.TempDir("", "gomail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "empty.txt")
+	if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := NewMessage()
+	if err := msg.Attach(filename); err != nil {
+		t.Errorf("Attach(%q) returned error: %v", filename, err)
+	}
+
+	msg.SetRejectEmptyFiles(true)
+	if err := msg.Attach(filename); err == nil || !strings.Contains(err.Error(), "empty file") {
+		t.Errorf("Attach(%q) = %v, want an empty file error", filename, err)
+	}
+	if _, err := msg.EmbedImage(filename); err == nil || !strings.Contains(err.Error(), "empty file") {
+		t.Errorf("EmbedImage(%q) = %v, want an empty file error", filename, err)
+	}
+	if len(msg.attachments) != 1 || len(msg.embedded) != 0 {
+		t.Errorf("Empty files should not be attached, got %d attachments and %d embedded files", len(msg.attachments), len(msg.embedded))
+	}
+}
+
+func TestMaxAttachments(t *testing.T) {
+	stat = stubStat
+
+	msg := NewMessage()
+	msg.SetMaxAttachments(2)
+	for _, filename := range []string{"/tmp/a.pdf", "/tmp/b.pdf"} {
+		if err := msg.Attach(filename); err != nil {
+			t.Errorf("Attach(%q) returned error: %v", filename, err)
+		}
+	}
+	if err := msg.Attach("/tmp/c.pdf"); err == nil || !strings.Contains(err.Error(), "already has 2 attachments") {
+		t.Errorf("Attach should return an error once the limit is reached, got %v", err)
+	}
+	if len(msg.attachments) != 2 {
+		t.Errorf("Invalid number of attachments, got %d, want 2", len(msg.attachments))
+	}
+
+	msg.SetMaxAttachments(0)
+	if err := msg.Attach("/tmp/c.pdf"); err != nil {
+		t.Errorf("Attach should not return an error without limit, got %v", err)
+	}
+}
+
+func TestMultipleAttachment(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	msg.Attach("/tmp/test.pdf")
+	msg.Attach("/tmp/test.zip")
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Test\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: application/zip; name=\"test.zip\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.zip\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.zip")) + "\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestDuplicateAttachmentNames(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	msg.SetRenameDuplicateAttachments(true)
+	msg.Attach("/tmp/a/test.pdf")
+	msg.Attach("/tmp/b/test.pdf")
+	msg.Attach("/tmp/c/test.pdf")
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+	}
+	body := ""
+	for _, name := range []string{"test.pdf", "test (2).pdf", "test (3).pdf"} {
+		body += "--" + boundary + "\r\n" +
+			"Content-Type: application/pdf; name=\"" + name + "\"\r\n" +
+			"Content-Disposition: attachment; filename=\"" + name + "\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n"
+	}
+	body += "--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestMultipleAttachmentOnly(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	msg.Attach("/tmp/test.pdf")
+	msg.Attach("/tmp/test.zip")
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: application/zip; name=\"test.zip\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.zip\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.zip")) + "\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestFullMessage(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "¡Hola, señor!")
+	msg.AddAlternative("text/html", "¡<b>Hola</b>, <i>señor</i>!</h1>")
+	msg.Attach("/tmp/test.pdf")
+
+	mainBoundary := getMainBoundary(t, msg)
+	subBoundary := getBodyBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + mainBoundary},
+	}
+	body := "--" + mainBoundary + "\r\n" +
+		"Content-Type: multipart/alternative; boundary=" + subBoundary + "\r\n" +
+		"\r\n" +
+		"--" + subBoundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"=C2=A1Hola, se=C3=B1or!\r\n" +
+		"--" + subBoundary + "\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"=C2=A1<b>Hola</b>, <i>se=C3=B1or</i>!</h1>\r\n" +
+		"--" + subBoundary + "--\r\n" +
+		"\r\n" +
+		"--" + mainBoundary + "\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"--" + mainBoundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestBodyReader(t *testing.T) {
+	want := NewMessage()
+	want.SetBody("text/plain", "¡Hola, señor!")
+	wantBody, err := ioutil.ReadAll(export(t, want).Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastExportedMessage = nil
+
+	msg := NewMessage()
+	if err := msg.SetBodyReader("text/plain", strings.NewReader("¡Hola, señor!")); err != nil {
+		t.Fatal(err)
+	}
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	}
+
+	testMessage(t, msg, header, string(wantBody))
+}
+
+func TestBodyReaderError(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	r := io.MultiReader(strings.NewReader("Hello"), errReader{})
+	if err := msg.SetBodyReader("text/plain", r); err == nil {
+		t.Error("SetBodyReader should return an error")
+	}
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "Test")
 }
 
-func TestAttachmentOnly(t *testing.T) {
-	readFile = stubReadFile
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("read error")
+}
 
+func TestBodyTemplate(t *testing.T) {
 	msg := NewMessage()
-	msg.Attach("/tmp/test.pdf")
+	tmpl := template.Must(template.New("test").Parse("Hello {{.Name}}"))
+	if err := msg.SetBodyTemplate("text/plain", tmpl, struct{ Name string }{"Bob"}); err != nil {
+		t.Fatal(err)
+	}
 
 	header := mail.Header{
 		"Mime-Version":              {"1.0"},
 		"Date":                      {"25 Jun 14 17:46 UTC"},
-		"Content-Type":              {"application/pdf; name=\"test.pdf\""},
-		"Content-Disposition":       {"attachment; filename=\"test.pdf\""},
-		"Content-Transfer-Encoding": {"base64"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
 	}
-	body := base64.StdEncoding.EncodeToString([]byte("Content of test.pdf"))
 
-	testMessage(t, msg, header, body)
+	testMessage(t, msg, header, "Hello Bob")
 }
 
-func TestMultipleAttachment(t *testing.T) {
-	readFile = stubReadFile
-
+func TestAlternativeTemplate(t *testing.T) {
 	msg := NewMessage()
-	msg.SetBody("text/plain", "Test")
-	msg.Attach("/tmp/test.pdf")
-	msg.Attach("/tmp/test.zip")
+	msg.SetBody("text/plain", "Hello Bob")
+	tmpl := template.Must(template.New("test").Parse("Hello <b>{{.Name}}</b>"))
+	if err := msg.AddAlternativeTemplate("text/html", tmpl, struct{ Name string }{"Bob"}); err != nil {
+		t.Fatal(err)
+	}
 
 	boundary := getMainBoundary(t, msg)
 
 	header := mail.Header{
 		"Mime-Version": {"1.0"},
 		"Date":         {"25 Jun 14 17:46 UTC"},
-		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+		"Content-Type": {"multipart/alternative; boundary=" + boundary},
 	}
 	body := "--" + boundary + "\r\n" +
 		"Content-Type: text/plain; charset=UTF-8\r\n" +
 		"Content-Transfer-Encoding: quoted-printable\r\n" +
 		"\r\n" +
-		"Test\r\n" +
-		"--" + boundary + "\r\n" +
-		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
-		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
-		"Content-Transfer-Encoding: base64\r\n" +
-		"\r\n" +
-		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
+		"Hello Bob\r\n" +
 		"--" + boundary + "\r\n" +
-		"Content-Type: application/zip; name=\"test.zip\"\r\n" +
-		"Content-Disposition: attachment; filename=\"test.zip\"\r\n" +
-		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
 		"\r\n" +
-		base64.StdEncoding.EncodeToString([]byte("Content of test.zip")) + "\r\n" +
+		"Hello <b>Bob</b>\r\n" +
 		"--" + boundary + "--\r\n"
 
 	testMessage(t, msg, header, body)
 }
 
-func TestMultipleAttachmentOnly(t *testing.T) {
-	readFile = stubReadFile
-
+func TestBodyTemplateError(t *testing.T) {
 	msg := NewMessage()
-	msg.Attach("/tmp/test.pdf")
-	msg.Attach("/tmp/test.zip")
-
-	boundary := getMainBoundary(t, msg)
+	msg.SetBody("text/plain", "Test")
+	tmpl := template.Must(template.New("test").Parse("Hello {{.Name}}"))
+	if err := msg.SetBodyTemplate("text/plain", tmpl, 42); err == nil {
+		t.Error("SetBodyTemplate should return an error")
+	}
 
 	header := mail.Header{
-		"Mime-Version": {"1.0"},
-		"Date":         {"25 Jun 14 17:46 UTC"},
-		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
 	}
-	body := "--" + boundary + "\r\n" +
-		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
-		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
-		"Content-Transfer-Encoding: base64\r\n" +
-		"\r\n" +
-		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
-		"--" + boundary + "\r\n" +
-		"Content-Type: application/zip; name=\"test.zip\"\r\n" +
-		"Content-Disposition: attachment; filename=\"test.zip\"\r\n" +
-		"Content-Transfer-Encoding: base64\r\n" +
-		"\r\n" +
-		base64.StdEncoding.EncodeToString([]byte("Content of test.zip")) + "\r\n" +
-		"--" + boundary + "--\r\n"
 
-	testMessage(t, msg, header, body)
+	testMessage(t, msg, header, "Test")
 }
 
-func TestFullMessage(t *testing.T) {
+type failingData struct{}
+
+func (failingData) Name() string { return "Bob" }
+
+func (failingData) Fail() (string, error) { return "", errors.New("template error") }
+
+func TestAlternativeHTMLTemplate(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Hello Bob")
+	tmpl := htmltemplate.Must(htmltemplate.New("test").Parse("Hello <b>{{.Name}}</b>{{.Fail}}"))
+	if err := msg.AddAlternativeTemplate("text/html", tmpl, failingData{}); err == nil {
+		t.Fatal("AddAlternativeTemplate should return an error")
+	}
+	if len(msg.parts) != 1 {
+		t.Fatalf("No body should be added when the template returns an error, got %d bodies", len(msg.parts))
+	}
+
+	tmpl = htmltemplate.Must(htmltemplate.New("test").Parse("Hello <b>{{.Name}}</b>"))
+	if err := msg.AddAlternativeTemplate("text/html", tmpl, failingData{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.parts) != 2 || msg.parts[1].body.String() != "Hello <b>Bob</b>" {
+		t.Errorf("Invalid bodies: %+v", msg.parts)
+	}
+}
+
+func TestPartHeaderFunc(t *testing.T) {
+	stat = stubStat
 	readFile = stubReadFile
 
 	msg := NewMessage()
-	msg.SetBody("text/plain", "¡Hola, señor!")
-	msg.AddAlternative("text/html", "¡<b>Hola</b>, <i>señor</i>!</h1>")
+	msg.SetBody("text/plain", "Test")
+	msg.AddAlternative("text/html", "<b>Test</b>")
 	msg.Attach("/tmp/test.pdf")
+	i := 0
+	msg.SetPartHeaderFunc(func(kind PartKind, h textproto.MIMEHeader) {
+		h.Set("X-Part-Index", fmt.Sprintf("%d-%d", i, kind))
+		i++
+	})
 
 	mainBoundary := getMainBoundary(t, msg)
 	subBoundary := getBodyBoundary(t, msg)
@@ -233,19 +1768,22 @@ func TestFullMessage(t *testing.T) {
 		"--" + subBoundary + "\r\n" +
 		"Content-Type: text/plain; charset=UTF-8\r\n" +
 		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"X-Part-Index: 0-1\r\n" +
 		"\r\n" +
-		"=C2=A1Hola, se=C3=B1or!\r\n" +
+		"Test\r\n" +
 		"--" + subBoundary + "\r\n" +
 		"Content-Type: text/html; charset=UTF-8\r\n" +
 		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"X-Part-Index: 1-1\r\n" +
 		"\r\n" +
-		"=C2=A1<b>Hola</b>, <i>se=C3=B1or</i>!</h1>\r\n" +
+		"<b>Test</b>\r\n" +
 		"--" + subBoundary + "--\r\n" +
 		"\r\n" +
 		"--" + mainBoundary + "\r\n" +
 		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
 		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
 		"Content-Transfer-Encoding: base64\r\n" +
+		"X-Part-Index: 2-2\r\n" +
 		"\r\n" +
 		base64.StdEncoding.EncodeToString([]byte("Content of test.pdf")) + "\r\n" +
 		"--" + mainBoundary + "--\r\n"
@@ -253,6 +1791,26 @@ func TestFullMessage(t *testing.T) {
 	testMessage(t, msg, header, body)
 }
 
+func TestPartHeaderFuncSinglePart(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	msg.SetPartHeaderFunc(func(kind PartKind, h textproto.MIMEHeader) {
+		if kind != BodyPart {
+			t.Errorf("Invalid part kind, got %d, want %d", kind, BodyPart)
+		}
+		h.Set("Content-Type", h.Get("Content-Type")+"; format=flowed")
+	})
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=UTF-8; format=flowed"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "Test")
+}
+
 func TestQpLineLength(t *testing.T) {
 	msg := NewMessage()
 	msg.SetBody("text/plain",
@@ -276,11 +1834,131 @@ func TestQpLineLength(t *testing.T) {
 		strings.Repeat("0", 76) + "=\r\n=C3=A0\r\n" +
 		strings.Repeat("0", 75) + "=C3=\r\n=A0\r\n" +
 		strings.Repeat("0", 78) + "\r\n" +
-		strings.Repeat("0", 78) + "=\r\n0\n"
+		strings.Repeat("0", 78) + "=\r\n0\r\n"
 
 	testMessage(t, msg, header, body)
 }
 
+func TestQpLongWord(t *testing.T) {
+	url := "https://example.com/caf%C3%A9?q=" + strings.Repeat("a%2Fb%3D", 25) + "&lang=fr"
+	content := "Café: " + url + "\r\n"
+	msg := NewMessage()
+	msg.SetBody("text/plain", content)
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(b), "\r\n") {
+		if len(line) > maxLineLen+1 {
+			t.Errorf("Line is too long: %q", line)
+		}
+	}
+
+	decoded, err := quotedprintable.DecodeString(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != content {
+		t.Errorf("Invalid decoded body, got %q, want %q", decoded, content)
+	}
+}
+
+func TestNormalizeNewlines(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "a\nb\r\nc\rd\n\ne\r\n\r\nf\r\r\n")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"7bit"},
+	}
+
+	testMessage(t, msg, header, "a\r\nb\r\nc\r\nd\r\n\r\ne\r\n\r\nf\r\n\r\n")
+}
+
+func TestRawNewlines(t *testing.T) {
+	msg := NewMessage()
+	msg.SetNormalizeNewlines(false)
+	msg.SetBody("text/plain", "a\nb\r\nc")
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	}
+
+	testMessage(t, msg, header, "a\nb\r\nc")
+}
+
+func TestRawNewlinesChunkBoundary(t *testing.T) {
+	// The carriage return is at the boundary of the chunks encoded at once and
+	// the body is not ASCII so that it is encoded in quoted-printable.
+	body := strings.Repeat("a", 4093) + "é\rb"
+	msg := NewMessage()
+	msg.SetNormalizeNewlines(false)
+	msg.SetBody("text/plain", body)
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(encoded, []byte("\r")); n != bytes.Count(encoded, []byte("\r\n")) {
+		t.Errorf("The encoded body should not contain a bare carriage return, got %q", encoded[len(encoded)-16:])
+	}
+	decoded, err := ioutil.ReadAll(quotedprintable.NewDecoder(bytes.NewReader(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != body {
+		t.Errorf("Invalid decoded body, got %q, want %q", decoded, body)
+	}
+}
+
+func TestQpLineWriter(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{strings.Repeat("0", 77) + "\r\n0"}, strings.Repeat("0", 77) + "\r\n0"},
+		{[]string{strings.Repeat("0", 78) + "\r\n0"}, strings.Repeat("0", 78) + "\r\n0"},
+		{[]string{strings.Repeat("0", 79) + "\r\n0"}, strings.Repeat("0", 78) + "=\r\n0\r\n0"},
+		{[]string{strings.Repeat("0", 77) + "\n0"}, strings.Repeat("0", 77) + "\n0"},
+		{[]string{strings.Repeat("0", 78) + "\n0"}, strings.Repeat("0", 78) + "\n0"},
+		{[]string{strings.Repeat("0", 79) + "\n0"}, strings.Repeat("0", 78) + "=\r\n0\n0"},
+		{[]string{strings.Repeat("0", 76) + "=C3\r\n"}, strings.Repeat("0", 76) + "=\r\n=C3\r\n"},
+		{[]string{strings.Repeat("0", 75) + "=C3\r\n"}, strings.Repeat("0", 75) + "=C3\r\n"},
+		// Hard line breaks split across writes
+		{[]string{strings.Repeat("0", 78) + "\r", "\n0"}, strings.Repeat("0", 78) + "\r\n0"},
+		{[]string{strings.Repeat("0", 77), "0\r\n", "0"}, strings.Repeat("0", 78) + "\r\n0"},
+		{[]string{strings.Repeat("0", 78), "\r\n0"}, strings.Repeat("0", 78) + "\r\n0"},
+		{[]string{strings.Repeat("0", 78), "0\r\n"}, strings.Repeat("0", 78) + "=\r\n0\r\n"},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		w := newQpLineWriter(buf)
+		for _, s := range test.in {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("qpLineWriter.Write(%q) = %d, %v, want %d, nil", s, n, err, len(s))
+			}
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("qpLineWriter(%q) wrote %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
 func TestBase64LineLength(t *testing.T) {
 	msg := NewCustomMessage("UTF-8", Base64)
 	msg.SetBody("text/plain", strings.Repeat("0", 58))
@@ -291,11 +1969,176 @@ func TestBase64LineLength(t *testing.T) {
 		"Content-Type":              {"text/plain; charset=UTF-8"},
 		"Content-Transfer-Encoding": {"base64"},
 	}
-	body := strings.Repeat("MDAw", 19) + "MA\r\n=="
+	body := strings.Repeat("MDAw", 19) + "\r\nMA=="
+
+	testMessage(t, msg, header, body)
+}
+
+func TestBase64CustomLineLength(t *testing.T) {
+	msg := NewCustomMessage("UTF-8", Base64)
+	if err := msg.SetBase64LineLength(64); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetBody("text/plain", strings.Repeat("0", 100))
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	}
+	line := strings.Repeat("MDAw", 16)
+	body := line + "\r\n" + line + "\r\nMDAwMA=="
+
+	testMessage(t, msg, header, body)
+
+	for _, n := range []int{0, -4, 78, 80, 1000} {
+		if err := msg.SetBase64LineLength(n); err == nil {
+			t.Errorf("SetBase64LineLength(%d) should return an error", n)
+		}
+	}
+}
+
+func TestBase64Wrapping(t *testing.T) {
+	stat = stubStat
+	readFile = func(filename string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(strings.Repeat("0", 300))), nil
+	}
+	defer func() {
+		readFile = stubReadFile
+	}()
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Test")
+	if err := msg.Attach("/tmp/test.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	msg.SetBase64Wrapping(false)
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Test\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"\r\n" +
+		strings.Repeat("MDAw", 100) + "\r\n" +
+		"--" + boundary + "--\r\n"
 
 	testMessage(t, msg, header, body)
 }
 
+func TestBase64LineWriter(t *testing.T) {
+	tests := []struct {
+		len  int
+		want string
+	}{
+		{55, strings.Repeat("MDAw", 18) + "MA=="},
+		{56, strings.Repeat("MDAw", 18) + "MDA="},
+		{57, strings.Repeat("MDAw", 19)},
+		{58, strings.Repeat("MDAw", 19) + "\r\nMA=="},
+		{112, strings.Repeat("MDAw", 19) + "\r\n" + strings.Repeat("MDAw", 18) + "MA=="},
+		{113, strings.Repeat("MDAw", 19) + "\r\n" + strings.Repeat("MDAw", 18) + "MDA="},
+	}
+
+	for _, test := range tests {
+		// Write byte by byte to check that the lines are correctly wrapped
+		// across Write boundaries.
+		buf := new(bytes.Buffer)
+		w := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(buf, maxBase64LineLen))
+		for i := 0; i < test.len; i++ {
+			w.Write([]byte("0"))
+		}
+		w.Close()
+
+		if got := buf.String(); got != test.want {
+			t.Errorf("Invalid base64 encoding of %d bytes, got %q, want %q", test.len, got, test.want)
+		}
+	}
+}
+
+func TestLineWritersClose(t *testing.T) {
+	tests := []struct {
+		w    func(io.Writer) io.WriteCloser
+		in   []string
+		want string
+	}{
+		{
+			func(w io.Writer) io.WriteCloser { return NewBase64LineWriter(w, 8) },
+			[]string{"MDAw", "MDAwMD", "Aw", "MA=="},
+			"MDAwMDAw\r\nMDAwMA==",
+		},
+		{
+			func(w io.Writer) io.WriteCloser { return NewQPLineWriter(w) },
+			[]string{strings.Repeat("0", 76), "=", "C3=A9"},
+			strings.Repeat("0", 76) + "=\r\n=C3=A9",
+		},
+		{
+			func(w io.Writer) io.WriteCloser { return NewQPLineWriter(w) },
+			[]string{strings.Repeat("0", 75), "=C", "3", "0"},
+			strings.Repeat("0", 75) + "=C3=\r\n0",
+		},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		w := test.w(buf)
+		for _, s := range test.in {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("Write(%q) = %d, %v, want %d, nil", s, n, err, len(s))
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Errorf("Close() returned an error after writing %q: %v", test.in, err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("Writing %q wrote %q, want %q", test.in, got, test.want)
+		}
+	}
+
+	w := NewQPLineWriter(new(bytes.Buffer))
+	w.Write([]byte("0=C"))
+	if err := w.Close(); err == nil {
+		t.Error("Close() should return an error when an encoded byte is incomplete")
+	}
+	// The writer is reset after being closed
+	w.Write([]byte("0"))
+	if err := w.Close(); err != nil {
+		t.Errorf("Close() returned an error after being reset: %v", err)
+	}
+}
+
+func TestLineWritersError(t *testing.T) {
+	errWrite := errors.New("write error")
+	writers := []io.Writer{
+		NewBase64LineWriter(failingWriter{errWrite}, maxBase64LineLen),
+		NewQPLineWriter(failingWriter{errWrite}),
+	}
+	for _, w := range writers {
+		if _, err := w.Write([]byte(strings.Repeat("0", 100))); err != errWrite {
+			t.Errorf("%T.Write() returned %v, want %v", w, err, errWrite)
+		}
+	}
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
 func testMessage(t *testing.T, msg *Message, header mail.Header, body string) {
 	m := export(t, msg)
 	defer func() {
@@ -323,8 +2166,8 @@ func testMessage(t *testing.T, msg *Message, header mail.Header, body string) {
 }
 
 func compareBodies(t *testing.T, r io.Reader, want string) {
-	// The ordering of headers' fields of sub-parts is random so we cannot do a
-	// simple comparison here.
+	// The expected fields of the headers of sub-parts can be listed in any
+	// order so we cannot do a simple comparison here.
 	body, err := ioutil.ReadAll(r)
 	if err != nil {
 		t.Fatal(err)
@@ -411,10 +2254,74 @@ func getBodyBoundary(t *testing.T, msg *Message) string {
 	return ""
 }
 
-func stubReadFile(filename string) ([]byte, error) {
-	return []byte("Content of " + filepath.Base(filename)), nil
+func stubReadFile(filename string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("Content of " + filepath.Base(filename))), nil
+}
+
+func stubStat(filename string) (os.FileInfo, error) {
+	return stubFileInfo{filepath.Base(filename)}, nil
 }
 
+type stubFileInfo struct {
+	name string
+}
+
+func (fi stubFileInfo) Name() string       { return fi.name }
+func (fi stubFileInfo) Size() int64        { return 0 }
+func (fi stubFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi stubFileInfo) ModTime() time.Time { return stubNow() }
+func (fi stubFileInfo) IsDir() bool        { return false }
+func (fi stubFileInfo) Sys() interface{}   { return nil }
+
 func stubNow() time.Time {
 	return time.Date(2014, 06, 25, 17, 46, 0, 0, time.UTC)
 }
+
+// serveSMTP runs a minimal SMTP server on conn. The server advertises the given
+// extensions, records the commands it receives in commands and all the data it
+// receives in received.
+func serveSMTP(conn net.Conn, received *bytes.Buffer, commands *[]string, extensions ...string) {
+	defer conn.Close()
+	r := bufio.NewReader(io.TeeReader(conn, received))
+	conn.Write([]byte("220 host ESMTP\r\n"))
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		*commands = append(*commands, cmd)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			lines := append([]string{"host"}, extensions...)
+			for i, line := range lines {
+				if i < len(lines)-1 {
+					conn.Write([]byte("250-" + line + "\r\n"))
+				} else {
+					conn.Write([]byte("250 " + line + "\r\n"))
+				}
+			}
+		case cmd == "DATA":
+			conn.Write([]byte("354 Go ahead\r\n"))
+			for line != ".\r\n" {
+				if line, err = r.ReadString('\n'); err != nil {
+					return
+				}
+			}
+			conn.Write([]byte("250 OK\r\n"))
+		case strings.HasPrefix(cmd, "BDAT "):
+			var size int
+			fmt.Sscanf(cmd, "BDAT %d", &size)
+			if _, err := io.ReadFull(r, make([]byte, size)); err != nil {
+				return
+			}
+			conn.Write([]byte("250 OK\r\n"))
+		case cmd == "QUIT":
+			conn.Write([]byte("221 Bye\r\n"))
+			return
+		default:
+			conn.Write([]byte("250 OK\r\n"))
+		}
+	}
+}
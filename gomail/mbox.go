@@ -0,0 +1,48 @@
+package gomail
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteMbox exports the message and writes it to w as an entry of an mbox file
+// in the mboxrd format, so that it can be appended to an mbox file. The entry
+// starts with a "From " line containing envelopeFrom and t, the lines of the
+// message starting with "From ", optionally preceded by ">" characters, are
+// escaped with an additional ">" and the line endings are converted to LF. If
+// envelopeFrom is empty, MAILER-DAEMON is used.
+func (msg *Message) WriteMbox(w io.Writer, envelopeFrom string, t time.Time) error {
+	if envelopeFrom == "" {
+		envelopeFrom = "MAILER-DAEMON"
+	} else if strings.ContainsAny(envelopeFrom, " \t\r\n") {
+		return errors.New("gomail: invalid mbox envelope sender, it contains whitespace")
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := msg.WriteTo(buf); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("From " + envelopeFrom + " " + t.UTC().Format(time.ANSIC) + "\n")
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if isMboxFromLine(line) {
+			bw.WriteString(">")
+		}
+		bw.WriteString(line + "\n")
+	}
+	// An empty line separates the entries.
+	bw.WriteString("\n")
+
+	return bw.Flush()
+}
+
+// isMboxFromLine returns true if line must be escaped in the mboxrd format.
+func isMboxFromLine(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, ">"), "From ")
+}
@@ -0,0 +1,79 @@
+package gomail
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMbox(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("From", "from@example.com")
+	msg.SetHeader("Subject", "Hello")
+	msg.SetDateHeader("Date", stubNow())
+	body := "From here\r\n>From there\r\nFrom\r\nBye"
+	msg.SetBody("text/plain", body)
+
+	buf := new(bytes.Buffer)
+	date := time.Date(2014, 6, 25, 17, 46, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := msg.WriteMbox(buf, "from@example.com", date); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "From from@example.com Wed Jun 25 17:46:00 2014\n" +
+		"Content-Transfer-Encoding: 7bit\n" +
+		"Content-Type: text/plain; charset=UTF-8\n" +
+		"Date: 25 Jun 14 17:46 UTC\n" +
+		"From: from@example.com\n" +
+		"Mime-Version: 1.0\n" +
+		"Subject: Hello\n" +
+		"\n" +
+		">From here\n" +
+		">>From there\n" +
+		"From\n" +
+		"Bye\n" +
+		"\n"
+	if got := buf.String(); got != want+want {
+		t.Fatalf("Invalid mbox, got:\n%s\nwant:\n%s", got, want+want)
+	}
+
+	// The entries are split on the "From " lines and unescaped.
+	entries := strings.Split(buf.String(), "\nFrom ")
+	if len(entries) != 2 {
+		t.Fatalf("The mbox should contain 2 entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		entry = entry[strings.Index(entry, "\n")+1:]
+		entry = strings.TrimSuffix(entry, "\n")
+		lines := strings.Split(entry, "\n")
+		for i, line := range lines {
+			if isMboxFromLine(line) && strings.HasPrefix(line, ">") {
+				lines[i] = line[1:]
+			}
+		}
+		m, err := mail.ReadMessage(strings.NewReader(strings.Join(lines, "\r\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Header.Get("Subject"); got != "Hello" {
+			t.Errorf("Invalid Subject field, got %q", got)
+		}
+		b, err := ioutil.ReadAll(m.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The line break ending the entry is not part of the message.
+		if got := strings.TrimSuffix(string(b), "\r\n"); got != body {
+			t.Errorf("Invalid body, got %q, want %q", got, body)
+		}
+	}
+
+	if err := msg.WriteMbox(buf, "from @example.com", date); err == nil {
+		t.Error("WriteMbox should return an error when the envelope sender contains whitespace")
+	}
+}
@@ -0,0 +1,72 @@
+package gomail
+
+import (
+	"net/mail"
+	"strings"
+
+	"github.com/alexcesaro/mail/quotedprintable"
+)
+
+// NewReply returns a new message replying to original. The reply is sent to
+// the Reply-To address of the original message, or else to its From address,
+// and sent from its To address if it has only one. The subject is prefixed by
+// "Re: " and the In-Reply-To and References fields are set as defined in RFC
+// 5322, section 3.6.4, so that clients display the reply in the same thread.
+func NewReply(original *mail.Message) *Message {
+	msg := NewMessage()
+	h := original.Header
+
+	if to := h.Get("Reply-To"); to != "" {
+		msg.header["To"] = []string{to}
+	} else if from := h.Get("From"); from != "" {
+		msg.header["To"] = []string{from}
+	}
+	if to, err := h.AddressList("To"); err == nil && len(to) == 1 {
+		msg.header["From"] = []string{h.Get("To")}
+	}
+
+	subject := h.Get("Subject")
+	if text, _, err := quotedprintable.DecodeHeader(subject); err != nil || !strings.HasPrefix(strings.ToLower(text), "re:") {
+		subject = strings.TrimSpace("Re: " + subject)
+	}
+	msg.header["Subject"] = []string{subject}
+
+	id := h.Get("Message-Id")
+	if id == "" {
+		return msg
+	}
+	msg.header["In-Reply-To"] = []string{id}
+	refs := h.Get("References")
+	if refs == "" {
+		refs = h.Get("In-Reply-To")
+	}
+	msg.header["References"] = []string{strings.TrimSpace(refs + " " + id)}
+
+	return msg
+}
+
+// QuoteText returns original quoted to be included in the plain text body of
+// a reply: each line is prefixed by "> ", or by ">" if it is already quoted so
+// that the quote levels read ">> ". Empty lines are prefixed by ">".
+func QuoteText(original string) string {
+	original = strings.TrimRight(original, "\r\n")
+	lines := strings.Split(original, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, ">"):
+			lines[i] = ">" + line
+		case strings.TrimRight(line, "\r") == "":
+			lines[i] = ">" + line
+		default:
+			lines[i] = "> " + line
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// QuoteHTML returns original quoted in a blockquote element to be included in
+// the HTML body of a reply. original must be HTML.
+func QuoteHTML(original string) string {
+	return "<blockquote type=\"cite\">" + original + "</blockquote>"
+}
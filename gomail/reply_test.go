@@ -0,0 +1,76 @@
+package gomail
+
+import (
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewReply(t *testing.T) {
+	raw := "From: =?UTF-8?Q?Se=C3=B1or?= <from@example.com>\r\n" +
+		"To: to@example.com\r\n" +
+		"Subject: =?UTF-8?Q?=C2=A1Hola!?=\r\n" +
+		"Message-ID: <3@example.com>\r\n" +
+		"In-Reply-To: <2@example.com>\r\n" +
+		"References: <1@example.com> <2@example.com>\r\n" +
+		"\r\n" +
+		"Hello"
+	original, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := NewReply(original)
+	want := header{
+		"From":        {"to@example.com"},
+		"To":          {"=?UTF-8?Q?Se=C3=B1or?= <from@example.com>"},
+		"Subject":     {"Re: =?UTF-8?Q?=C2=A1Hola!?="},
+		"In-Reply-To": {"<3@example.com>"},
+		"References":  {"<1@example.com> <2@example.com> <3@example.com>"},
+	}
+	if !reflect.DeepEqual(reply.header, want) {
+		t.Errorf("Invalid header, got %q, want %q", reply.header, want)
+	}
+}
+
+func TestNewReplyNoReferences(t *testing.T) {
+	original := &mail.Message{Header: mail.Header{
+		"From":       {"from@example.com"},
+		"Reply-To":   {"list@example.com"},
+		"To":         {"a@example.com, b@example.com"},
+		"Subject":    {"RE: Hello"},
+		"Message-Id": {"<1@example.com>"},
+	}}
+
+	reply := NewReply(original)
+	want := header{
+		"To":          {"list@example.com"},
+		"Subject":     {"RE: Hello"},
+		"In-Reply-To": {"<1@example.com>"},
+		"References":  {"<1@example.com>"},
+	}
+	if !reflect.DeepEqual(reply.header, want) {
+		t.Errorf("Invalid header, got %q, want %q", reply.header, want)
+	}
+}
+
+func TestQuoteText(t *testing.T) {
+	tests := []struct {
+		original, want string
+	}{
+		{"Hello,\n\n> Are you there?\nYes!\n", "> Hello,\n>\n>> Are you there?\n> Yes!\n"},
+		{"Hello,\r\n>> Hi\r\n\r\nBye", "> Hello,\r\n>>> Hi\r\n>\r\n> Bye\n"},
+		{"", ">\n"},
+	}
+
+	for _, test := range tests {
+		if got := QuoteText(test.original); got != test.want {
+			t.Errorf("QuoteText(%q) = %q, want %q", test.original, got, test.want)
+		}
+	}
+
+	if got, want := QuoteHTML("<p>Hello</p>"), `<blockquote type="cite"><p>Hello</p></blockquote>`; got != want {
+		t.Errorf("QuoteHTML() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package gomail
+
+import (
+	"mime"
+	"net/mail"
+	"net/textproto"
+)
+
+// A Report describes the structure chosen by Export for a message.
+type Report struct {
+	// Parts lists the parts of the message, the multipart parts included, in
+	// the order in which they are written.
+	Parts []PartReport
+}
+
+// A PartReport describes a part of an exported message.
+type PartReport struct {
+	// Depth is the nesting level of the part, 0 being the message itself.
+	Depth int
+	// ContentType is the media type of the part, without its parameters.
+	ContentType string
+	// Encoding is the Content-Transfer-Encoding of the part. It is empty for
+	// the multipart parts.
+	Encoding string
+	// Boundary is the boundary of the multipart parts.
+	Boundary string
+}
+
+// ExportWithReport is like Export but it also returns a report describing the
+// structure and the encodings chosen for the message, which can be used for
+// debugging or to test the structure of a message without parsing it.
+func (msg *Message) ExportWithReport() (*mail.Message, Report, error) {
+	var report Report
+	m, err := msg.export(false, &report)
+	if err != nil {
+		return nil, Report{}, err
+	}
+
+	return m, report, nil
+}
+
+func newPartReport(depth int, h textproto.MIMEHeader) PartReport {
+	// The parts of a multipart/digest part have no Content-Type field since
+	// their default content type is message/rfc822.
+	mediaType := "message/rfc822"
+	if ct := h.Get("Content-Type"); ct != "" {
+		mediaType, _, _ = mime.ParseMediaType(ct)
+	}
+
+	return PartReport{
+		Depth:       depth,
+		ContentType: mediaType,
+		Encoding:    h.Get("Content-Transfer-Encoding"),
+	}
+}
+
+// addSignature adds the parts added by the S/MIME signature of m.
+func (r *Report) addSignature(m *mail.Message) {
+	_, params, _ := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	parts := make([]PartReport, 0, len(r.Parts)+2)
+	parts = append(parts, PartReport{ContentType: "multipart/signed", Boundary: params["boundary"]})
+	for _, p := range r.Parts {
+		p.Depth++
+		parts = append(parts, p)
+	}
+	parts = append(parts, PartReport{Depth: 1, ContentType: "application/pkcs7-signature", Encoding: Base64})
+	r.Parts = parts
+}
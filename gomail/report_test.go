@@ -0,0 +1,79 @@
+package gomail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestExportWithReport(t *testing.T) {
+	stat = stubStat
+	readFile = stubReadFile
+
+	msg := NewMessage()
+	n := 0
+	msg.SetBoundaryFunc(func() string {
+		n++
+		return fmt.Sprintf("boundary%d", n)
+	})
+	msg.SetBody("text/plain", "Test")
+	msg.AddAlternative("text/html", "<b>Café</b>")
+	if _, err := msg.EmbedImage("/tmp/image.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.AttachWithOptions("/tmp/test.txt", AttachOptions{Encoding: QuotedPrintable}); err != nil {
+		t.Fatal(err)
+	}
+	msg.AttachRFC822("forwarded.eml", []byte("Subject: Hello\r\n\r\nHello"))
+
+	_, report, err := msg.ExportWithReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PartReport{
+		{Depth: 0, ContentType: "multipart/mixed", Boundary: "boundary1"},
+		{Depth: 1, ContentType: "multipart/related", Boundary: "boundary2"},
+		{Depth: 2, ContentType: "multipart/alternative", Boundary: "boundary3"},
+		{Depth: 3, ContentType: "text/plain", Encoding: QuotedPrintable},
+		{Depth: 3, ContentType: "text/html", Encoding: QuotedPrintable},
+		{Depth: 2, ContentType: "image/png", Encoding: Base64},
+		{Depth: 1, ContentType: "text/plain", Encoding: QuotedPrintable},
+		{Depth: 1, ContentType: "message/rfc822", Encoding: SevenBit},
+	}
+	if !reflect.DeepEqual(report.Parts, want) {
+		t.Errorf("Invalid report, got:\n%+v\nwant:\n%+v", report.Parts, want)
+	}
+
+	msg = NewMessage()
+	msg.SetBody("text/plain", "Test")
+	_, report, err = msg.ExportWithReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []PartReport{{Depth: 0, ContentType: "text/plain", Encoding: SevenBit}}
+	if !reflect.DeepEqual(report.Parts, want) {
+		t.Errorf("Invalid report, got:\n%+v\nwant:\n%+v", report.Parts, want)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.SetBoundaryFunc(func() string { return "signed" })
+	msg.SetSMIMESigner(signingCert(t, key), key)
+	_, report, err = msg.ExportWithReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []PartReport{
+		{Depth: 0, ContentType: "multipart/signed", Boundary: "signed"},
+		{Depth: 1, ContentType: "text/plain", Encoding: SevenBit},
+		{Depth: 1, ContentType: "application/pkcs7-signature", Encoding: Base64},
+	}
+	if !reflect.DeepEqual(report.Parts, want) {
+		t.Errorf("Invalid report, got:\n%+v\nwant:\n%+v", report.Parts, want)
+	}
+}
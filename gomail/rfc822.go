@@ -0,0 +1,116 @@
+package gomail
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"sort"
+
+	"github.com/alexcesaro/mail/quotedprintable"
+)
+
+// embeddedMessage is an email embedded in a message/rfc822 part.
+type embeddedMessage struct {
+	name string
+	raw  []byte
+}
+
+// AttachMessage embeds an email in the message as a message/rfc822 part. The
+// body of m is read when AttachMessage is called.
+func (msg *Message) AttachMessage(m *mail.Message) error {
+	raw, err := serializeMessage(m)
+	if err != nil {
+		return err
+	}
+	msg.messages = append(msg.messages, embeddedMessage{raw: raw})
+
+	return nil
+}
+
+// AttachRFC822 attaches a raw email to the message as a message/rfc822 part
+// named name, the way email clients forward an email as an attachment. The
+// email is embedded verbatim.
+func (msg *Message) AttachRFC822(name string, raw []byte) {
+	msg.messages = append(msg.messages, embeddedMessage{name: name, raw: raw})
+}
+
+// SetDigest sets whether the emails embedded with AttachMessage are grouped in
+// a multipart/digest part as defined in RFC 2046, section 5.1.5. It is
+// disabled by default.
+func (msg *Message) SetDigest(digest bool) {
+	msg.digest = digest
+}
+
+// serializeMessage returns the raw content of m. The fields of the header are
+// sorted so that the result does not depend on the order of the map.
+func serializeMessage(m *mail.Message) ([]byte, error) {
+	fields := make([]string, 0, len(m.Header))
+	for field := range m.Header {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	buf := new(bytes.Buffer)
+	for _, field := range fields {
+		for _, value := range m.Header[field] {
+			buf.WriteString(field + ": " + value + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+	if m.Body != nil {
+		if _, err := io.Copy(buf, m.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (msg *Message) isDigest() bool {
+	return msg.digest && len(msg.messages) > 0
+}
+
+func (msg *Message) writeMessages(w *messageWriter) error {
+	if msg.isDigest() {
+		if err := w.openMultipart("digest", nil); err != nil {
+			return err
+		}
+	}
+	for _, m := range msg.messages {
+		h := make(textproto.MIMEHeader)
+		// In a multipart/digest, the default content type of the parts is
+		// message/rfc822.
+		if m.name != "" {
+			h.Set("Content-Type", "message/rfc822; "+quotedprintable.EncodeParam("name", m.name))
+			h.Set("Content-Disposition", "attachment; "+quotedprintable.EncodeParam("filename", m.name))
+		} else if !msg.isDigest() {
+			h.Set("Content-Type", "message/rfc822")
+		}
+		// RFC 2046, section 5.2.1 does not allow encoding message/rfc822
+		// parts with base64 or quoted-printable.
+		h.Set("Content-Transfer-Encoding", messageEncoding(m.raw))
+
+		msg.writePartHeader(w, MessagePart, h)
+		if err := w.writeBody(bytes.NewReader(m.raw), eightBit); err != nil {
+			return err
+		}
+	}
+	if msg.isDigest() {
+		w.closeMultipart()
+	}
+
+	return nil
+}
+
+// messageEncoding returns 7bit if raw only contains ASCII characters and 8bit
+// otherwise.
+func messageEncoding(raw []byte) string {
+	for _, b := range raw {
+		if b >= 0x80 {
+			return eightBit
+		}
+	}
+
+	return SevenBit
+}
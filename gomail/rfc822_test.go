@@ -0,0 +1,168 @@
+package gomail
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	msg := NewMessage()
+	msg.SetHeader("Subject", "Digest")
+	msg.SetBody("text/plain", "Today's messages")
+	msg.SetDigest(true)
+	for _, subject := range []string{"First", "Second"} {
+		err := msg.AttachMessage(&mail.Message{
+			Header: mail.Header{"From": {"from@example.com"}, "Subject": {subject}},
+			Body:   strings.NewReader(subject + " message"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Invalid Content-Type, got %q", m.Header.Get("Content-Type"))
+	}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	if _, err := r.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err = mime.ParseMediaType(p.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/digest" {
+		t.Fatalf("Invalid Content-Type of the digest, got %q", p.Header.Get("Content-Type"))
+	}
+
+	want := []string{
+		"From: from@example.com\r\nSubject: First\r\n\r\nFirst message",
+		"From: from@example.com\r\nSubject: Second\r\n\r\nSecond message",
+	}
+	dr := multipart.NewReader(p, params["boundary"])
+	for i, w := range want {
+		part, err := dr.NextPart()
+		if err != nil {
+			t.Fatalf("Digest part #%d: %v", i, err)
+		}
+		if ct := part.Header.Get("Content-Type"); ct != "" {
+			t.Errorf("Digest part #%d should not have a Content-Type, got %q", i, ct)
+		}
+		if cte := part.Header.Get("Content-Transfer-Encoding"); cte != "7bit" {
+			t.Errorf("Invalid Content-Transfer-Encoding of digest part #%d, got %q, want %q", i, cte, "7bit")
+		}
+		b, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != w {
+			t.Errorf("Invalid digest part #%d, got %q, want %q", i, b, w)
+		}
+	}
+	if _, err := dr.NextPart(); err == nil {
+		t.Error("The digest should only contain 2 parts")
+	}
+}
+
+func TestAttachMessage(t *testing.T) {
+	msg := NewMessage()
+	err := msg.AttachMessage(&mail.Message{
+		Header: mail.Header{"Subject": {"Café"}},
+		Body:   strings.NewReader("¡Hola!"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := mail.Header{
+		"Mime-Version":              {"1.0"},
+		"Date":                      {"25 Jun 14 17:46 UTC"},
+		"Content-Type":              {"message/rfc822"},
+		"Content-Transfer-Encoding": {"8bit"},
+	}
+
+	testMessage(t, msg, header, "Subject: Café\r\n\r\n¡Hola!")
+}
+
+func TestAttachRFC822(t *testing.T) {
+	raw := "Received: from mx.example.com\r\n" +
+		"From: Bob <bob@example.com>\r\n" +
+		"Subject: =?UTF-8?Q?Caf=C3=A9?=\r\n" +
+		"\r\n" +
+		"Hello!"
+
+	msg := NewMessage()
+	msg.SetBody("text/plain", "See the forwarded email.")
+	msg.AttachRFC822("original.eml", []byte(raw))
+
+	boundary := getMainBoundary(t, msg)
+
+	header := mail.Header{
+		"Mime-Version": {"1.0"},
+		"Date":         {"25 Jun 14 17:46 UTC"},
+		"Content-Type": {"multipart/mixed; boundary=" + boundary},
+	}
+	body := "--" + boundary + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"See the forwarded email.\r\n" +
+		"--" + boundary + "\r\n" +
+		"Content-Type: message/rfc822; name=\"original.eml\"\r\n" +
+		"Content-Disposition: attachment; filename=\"original.eml\"\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"\r\n" +
+		raw + "\r\n" +
+		"--" + boundary + "--\r\n"
+
+	testMessage(t, msg, header, body)
+}
+
+func TestAttachRFC822Name(t *testing.T) {
+	names := []string{`Re: "Hello".eml`, "a; b.eml", "Café.eml"}
+	msg := NewMessage()
+	msg.SetBody("text/plain", "See the forwarded emails.")
+	for _, name := range names {
+		msg.AttachRFC822(name, []byte("Subject: Hello\r\n\r\nHello!"))
+	}
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = WalkParts(m, func(h textproto.MIMEHeader, body io.Reader) error {
+		mediaType, typeParams, err := mime.ParseMediaType(h.Get("Content-Type"))
+		if err != nil || mediaType != "message/rfc822" {
+			return err
+		}
+		_, params, err := mime.ParseMediaType(h.Get("Content-Disposition"))
+		if err != nil {
+			return err
+		}
+		if params["filename"] != typeParams["name"] {
+			t.Errorf("The filename %q differs from the name %q", params["filename"], typeParams["name"])
+		}
+		got = append(got, params["filename"])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, "|") != strings.Join(names, "|") {
+		t.Errorf("Invalid filenames, got %q, want %q", got, names)
+	}
+}
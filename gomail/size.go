@@ -0,0 +1,125 @@
+package gomail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const (
+	// boundaryLen is the length of the boundaries generated by
+	// mime/multipart.
+	boundaryLen = 60
+	// partHeaderLen is a large estimate of the length of the fields added to
+	// the header of a part in addition to its content type and name.
+	partHeaderLen = 256
+)
+
+// EstimatedSize returns an estimate of the size in bytes of the message once
+// exported and sent, without encoding it. It is an upper bound of the actual
+// size unless fields are added with the function set by SetPartHeaderFunc or
+// attachments are modified before the message is exported.
+func (msg *Message) EstimatedSize() int64 {
+	var size int64
+	for field, values := range msg.header {
+		for _, v := range values {
+			size += int64(len(field) + len(v) + 4)
+		}
+	}
+	// Default fields and the empty line ending the header
+	size += partHeaderLen + 2
+
+	multiparts := 0
+	if msg.isMixed() {
+		multiparts++
+	}
+	if msg.isAlternative() {
+		multiparts++
+	}
+	if msg.isDigest() {
+		multiparts++
+	}
+	if msg.isRelated() {
+		multiparts++
+	}
+	// Closing boundaries
+	size += int64(multiparts * (boundaryLen + 8))
+
+	for _, p := range msg.parts {
+		size += partOverhead(len(p.contentType) + len(msg.charset))
+		// Normalizing the line endings adds at most one byte per CR or LF
+		n := p.body.Len() + bytes.Count(p.body.Bytes(), []byte("\r")) + bytes.Count(p.body.Bytes(), []byte("\n"))
+		size += msg.encodedLen(int64(n), p.transferEncoding(msg.encoding))
+	}
+	for _, files := range [][]attachment{msg.embedded, msg.attachments} {
+		for _, a := range files {
+			size += partOverhead(2*len(a.name) + len(a.cid))
+			if n, err := a.fileSize(); err == nil {
+				size += msg.encodedLen(n, a.encoding)
+			}
+		}
+	}
+	for _, m := range msg.messages {
+		size += partOverhead(2*len(m.name)) + int64(len(m.raw))
+	}
+	if msg.smimeKey != nil {
+		// The multipart/signed part wrapping the content and the signature
+		size += 3*int64(boundaryLen+6) + partOverhead(0)
+		encoded := (msg.maxSignatureLen() + 2) / 3 * 4
+		size += encoded + (encoded/maxBase64LineLen+1)*2
+	}
+
+	return size
+}
+
+// SetMaxSize sets the maximum size in bytes of the body of the exported
+// message. Export returns an error as soon as the encoded body exceeds it, so
+// that a pathological input, like a long line of non-ASCII characters that
+// triples in size when encoded in quoted-printable, is not fully encoded. A
+// size of 0, the default, means no limit.
+func (msg *Message) SetMaxSize(n int64) {
+	msg.maxSize = n
+}
+
+// sizeLimitWriter returns an error if writing to w would make buf, the body
+// of the message, longer than maxSize.
+type sizeLimitWriter struct {
+	w       io.Writer
+	buf     *bytes.Buffer
+	maxSize int64
+}
+
+func (w *sizeLimitWriter) Write(p []byte) (int, error) {
+	if int64(w.buf.Len()+len(p)) > w.maxSize {
+		return 0, fmt.Errorf("gomail: the message exceeds the maximum size of %d bytes", w.maxSize)
+	}
+
+	return w.w.Write(p)
+}
+
+// partOverhead returns the maximum length of a boundary and of the header of a
+// part with fields of length n.
+func partOverhead(n int) int64 {
+	return int64(boundaryLen + 6 + partHeaderLen + n)
+}
+
+// encodedLen returns the maximum length of n bytes encoded using the given
+// encoding, line breaks included. It does not use the EncodedLen functions so
+// that the sizes of large files do not overflow an int.
+func (msg *Message) encodedLen(n int64, encoding string) int64 {
+	switch encoding {
+	case Base64:
+		lineLen := int64(msg.base64LineLen)
+		if lineLen == 0 {
+			lineLen = maxBase64LineLen
+		}
+		encoded := (n + 2) / 3 * 4
+		return encoded + (encoded/lineLen+1)*2
+	case QuotedPrintable:
+		encoded := 3 * n
+		// Soft line breaks
+		return encoded + (encoded/(maxLineLen-3)+1)*3
+	}
+
+	return n
+}
@@ -0,0 +1,118 @@
+package gomail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEstimatedSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "image.png")
+	if err := ioutil.WriteFile(image, []byte(strings.Repeat("\x89PNG", 1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	text := filepath.Join(dir, "notes.txt")
+	if err := ioutil.WriteFile(text, []byte(strings.Repeat("Café=\n", 200)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stat = os.Stat
+	readFile = func(filename string) (io.ReadCloser, error) {
+		return os.Open(filename)
+	}
+	defer func() {
+		stat = stubStat
+		readFile = stubReadFile
+	}()
+
+	single := NewMessage()
+	single.SetHeader("Subject", "¡Hola, señor!")
+	single.SetBody("text/plain", "Hello!")
+
+	alternative := NewMessage()
+	alternative.SetAddressHeader("From", "from@example.com", "Señor From")
+	alternative.SetBody("text/plain", strings.Repeat("¡Hola, señor!\n", 100))
+	alternative.AddAlternative("text/html", strings.Repeat("<b>¡Hola, señor!</b>\r", 100))
+
+	full := NewCustomMessage("UTF-8", Base64)
+	full.SetBody("text/plain", strings.Repeat("=", 1000))
+	full.AttachRFC822("original.eml", []byte("Subject: Test\r\n\r\nTest"))
+	if err := full.Attach(image); err != nil {
+		t.Fatal(err)
+	}
+	if err := full.AttachWithOptions(text, AttachOptions{Encoding: QuotedPrintable}); err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigned := NewMessage()
+	rsaSigned.SetBody("text/plain", "Hello")
+	rsaSigned.SetSMIMESigner(signingCert(t, rsaKey), rsaKey)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSigned := NewMessage()
+	ecdsaSigned.SetBody("text/plain", "Hello")
+	ecdsaSigned.SetSMIMESigner(signingCert(t, ecdsaKey), ecdsaKey)
+
+	for i, msg := range []*Message{single, alternative, full, rsaSigned, ecdsaSigned} {
+		m, err := msg.Export()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := exportedSize(t, m)
+		if estimate := msg.EstimatedSize(); estimate < actual || estimate > 2*actual+4096 {
+			t.Errorf("Invalid estimated size of message #%d, got %d, actual size is %d", i, estimate, actual)
+		}
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	// The body is under the limit but not once encoded in quoted-printable.
+	body := strings.Repeat("é", 4000)
+	msg := NewMessage()
+	msg.SetBody("text/plain", body)
+	msg.SetMaxSize(int64(len(body)) + 1000)
+	if _, err := msg.Export(); err == nil || !strings.Contains(err.Error(), "maximum size") {
+		t.Errorf("Export should return an error when the encoded body exceeds the maximum size, got %v", err)
+	}
+
+	msg.SetMaxSize(4 * int64(len(body)))
+	if _, err := msg.Export(); err != nil {
+		t.Errorf("Export returned an error under the maximum size: %v", err)
+	}
+	msg.SetMaxSize(0)
+	if _, err := msg.Export(); err != nil {
+		t.Errorf("Export returned an error without maximum size: %v", err)
+	}
+}
+
+func exportedSize(t *testing.T, m *mail.Message) int64 {
+	body, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(body) + 2)
+	for field, values := range m.Header {
+		size += int64(len(field) + len(strings.Join(values, ", ")) + 4)
+	}
+
+	return size
+}
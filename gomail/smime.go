@@ -0,0 +1,272 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SetSMIMESigner sets the certificate and the private key used to sign the
+// message with S/MIME as defined in RFC 8551. The message is exported as a
+// multipart/signed part containing the content of the message and a detached
+// signature in PKCS #7 format, computed using SHA-256. The key must be an RSA
+// or ECDSA key. Since the signed content must not be modified in transit, the
+// message cannot be signed if it contains 8bit parts. A nil key disables the
+// signature.
+func (msg *Message) SetSMIMESigner(cert *x509.Certificate, key crypto.Signer) {
+	msg.smimeCert = cert
+	msg.smimeKey = key
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// The following types are the ASN.1 structures defined in RFC 5652.
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+// encapContentInfo has no content since the signature is detached.
+type encapContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// signSMIME wraps m in a multipart/signed part.
+func (msg *Message) signSMIME(m *mail.Message) (*mail.Message, error) {
+	content, err := ioutil.ReadAll(m.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fields describing the content are moved to the signed part.
+	entity := new(bytes.Buffer)
+	fields := make([]string, 0, 4)
+	for field := range m.Header {
+		if strings.HasPrefix(field, "Content-") {
+			fields = append(fields, field)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		ri, rj := partFieldRank(fields[i]), partFieldRank(fields[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return fields[i] < fields[j]
+	})
+	for _, field := range fields {
+		for _, v := range m.Header[field] {
+			entity.WriteString(field + ": " + v + "\r\n")
+		}
+		delete(m.Header, field)
+	}
+	entity.WriteString("\r\n")
+	entity.Write(content)
+	if !is7bit(entity.Bytes()) {
+		return nil, errors.New("gomail: cannot sign a message containing 8bit parts with S/MIME")
+	}
+
+	signature, err := msg.pkcs7Signature(entity.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	mw := multipart.NewWriter(ioutil.Discard)
+	if msg.boundary != nil {
+		if err := mw.SetBoundary(msg.boundary()); err != nil {
+			return nil, fmt.Errorf("gomail: invalid boundary: %v", err)
+		}
+	}
+	boundary := mw.Boundary()
+	m.Header["Content-Type"] = []string{mime.FormatMediaType("multipart/signed", map[string]string{
+		"protocol": "application/pkcs7-signature",
+		"micalg":   "sha-256",
+		"boundary": boundary,
+	})}
+
+	body := new(bytes.Buffer)
+	body.WriteString("--" + boundary + "\r\n")
+	body.Write(entity.Bytes())
+	body.WriteString("\r\n--" + boundary + "\r\n" +
+		"Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"smime.p7s\"\r\n" +
+		"\r\n")
+	lineWriter := newBase64LineWriter(body, maxBase64LineLen)
+	encoder := base64.NewEncoder(base64.StdEncoding, lineWriter)
+	encoder.Write(signature)
+	encoder.Close()
+	lineWriter.Close()
+	body.WriteString("\r\n--" + boundary + "--\r\n")
+
+	return &mail.Message{Header: m.Header, Body: body}, nil
+}
+
+// pkcs7Signature returns the detached signature of content encoded in DER.
+func (msg *Message) pkcs7Signature(content []byte) ([]byte, error) {
+	if msg.smimeCert == nil {
+		return nil, errors.New("gomail: no S/MIME certificate")
+	}
+	var sigAlg pkix.AlgorithmIdentifier
+	switch msg.smimeKey.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSASHA256}
+	default:
+		return nil, fmt.Errorf("gomail: unsupported S/MIME key type %T", msg.smimeKey.Public())
+	}
+
+	clock := now
+	if msg.now != nil {
+		clock = msg.now
+	}
+	digest := sha256.Sum256(content)
+	attrs, err := signedAttributes(digest[:], clock().UTC())
+	if err != nil {
+		return nil, err
+	}
+	// The signature is computed over the DER encoding of the attributes as a
+	// SET OF, as required by RFC 5652, section 5.4.
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256(set)
+	signature, err := msg.smimeKey.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	digestAlg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: encapContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: msg.smimeCert.Raw},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: msg.smimeCert.RawIssuer},
+				SerialNumber: msg.smimeCert.SerialNumber,
+			},
+			DigestAlgorithm:    digestAlg,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// maxSignatureLen returns the maximum length of the signature returned by
+// pkcs7Signature.
+func (msg *Message) maxSignatureLen() int64 {
+	// The signed attributes and the ASN.1 structures are less than 512 bytes.
+	n := int64(512)
+	if msg.smimeCert != nil {
+		n += int64(len(msg.smimeCert.Raw))
+	}
+	switch pub := msg.smimeKey.Public().(type) {
+	case *rsa.PublicKey:
+		n += int64(pub.Size())
+	case *ecdsa.PublicKey:
+		// Two integers and their DER headers
+		n += int64(2*((pub.Curve.Params().BitSize+7)/8) + 9)
+	}
+
+	return n
+}
+
+// signedAttributes returns the content of the signed attributes, sorted as
+// required by DER.
+func signedAttributes(digest []byte, signingTime time.Time) ([]byte, error) {
+	values := []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, signingTime},
+		{oidMessageDigest, digest},
+	}
+
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		value, err := asn1.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i], err = asn1.Marshal(attribute{
+			Type:   v.oid,
+			Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return bytes.Join(encoded, nil), nil
+}
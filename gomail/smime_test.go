@@ -0,0 +1,170 @@
+package gomail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSMIMESignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key crypto.Signer
+		alg x509.SignatureAlgorithm
+	}{
+		{rsaKey, x509.SHA256WithRSA},
+		{ecdsaKey, x509.ECDSAWithSHA256},
+	}
+
+	for _, test := range tests {
+		cert := signingCert(t, test.key)
+		msg := NewMessage()
+		msg.SetHeader("From", "from@example.com")
+		msg.SetHeader("Subject", "Signed")
+		msg.SetBodies("Hello!", "<b>Hello!</b>")
+		msg.SetSMIMESigner(cert, test.key)
+
+		m, err := msg.Export()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mediaType != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" || params["micalg"] != "sha-256" {
+			t.Fatalf("Invalid Content-Type field: %q", m.Header.Get("Content-Type"))
+		}
+		if got := m.Header.Get("Content-Transfer-Encoding"); got != "" {
+			t.Errorf("Content-Transfer-Encoding should be moved to the signed part, got %q", got)
+		}
+		body, err := ioutil.ReadAll(m.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		delimiter := "--" + params["boundary"]
+		parts := strings.Split(string(body), "\r\n"+delimiter)
+		if len(parts) != 3 || !strings.HasPrefix(parts[0], delimiter+"\r\n") || parts[2] != "--\r\n" {
+			t.Fatalf("Invalid multipart/signed body:\n%s", body)
+		}
+		content := strings.TrimPrefix(parts[0], delimiter+"\r\n")
+		if !strings.HasPrefix(content, "Content-Type: multipart/alternative; boundary=") {
+			t.Errorf("The signed part should contain the body, got:\n%s", content)
+		}
+		sigPart := strings.SplitN(parts[1], "\r\n\r\n", 2)
+		if len(sigPart) != 2 || !strings.Contains(sigPart[0], "Content-Type: application/pkcs7-signature") {
+			t.Fatalf("Invalid signature part:\n%s", parts[1])
+		}
+		der, err := base64.StdEncoding.DecodeString(strings.Replace(sigPart[1], "\r\n", "", -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifySignature(t, der, []byte(content), cert, test.alg)
+	}
+}
+
+func TestSMIMESignature8bit(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := NewMessage()
+	msg.SetBody("text/plain", "Hello!")
+	msg.AttachRFC822("forwarded.eml", []byte("Subject: Café\r\n\r\nCafé"))
+	msg.SetSMIMESigner(signingCert(t, key), key)
+	if _, err := msg.Export(); err == nil {
+		t.Error("Export should return an error when signing 8bit parts")
+	}
+}
+
+func signingCert(t *testing.T, key crypto.Signer) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		Subject:        pkix.Name{CommonName: "from@example.com"},
+		EmailAddresses: []string{"from@example.com"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func verifySignature(t *testing.T, der, content []byte, cert *x509.Certificate, alg x509.SignatureAlgorithm) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatal(err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("Invalid content type %v", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := x509.ParseCertificate(sd.Certificates.Bytes); err != nil || !c.Equal(cert) {
+		t.Errorf("The signature should contain the signing certificate, got error %v", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		t.Fatalf("Invalid number of signers: %d", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	if si.SID.SerialNumber.Cmp(cert.SerialNumber) != 0 || !bytes.Equal(si.SID.Issuer.FullBytes, cert.RawIssuer) {
+		t.Error("Invalid signer identifier")
+	}
+
+	var digest []byte
+	for rest := si.SignedAttrs.Bytes; len(rest) > 0; {
+		var attr attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			t.Fatal(err)
+		}
+		if attr.Type.Equal(oidMessageDigest) {
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if want := sha256.Sum256(content); !bytes.Equal(digest, want[:]) {
+		t.Errorf("Invalid message digest, got %x, want %x", digest, want)
+	}
+
+	// The signature is computed over the attributes encoded as a SET OF.
+	signed := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	if err := cert.CheckSignature(alg, signed, si.Signature); err != nil {
+		t.Errorf("Invalid signature: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package gomail
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+var addressFields = []string{"From", "Sender", "Reply-To", "To", "Cc", "Bcc"}
+
+// Validate checks the message for common mistakes and returns the problems
+// found. It returns nil if no problem was found. The message is not modified.
+func (msg *Message) Validate() []error {
+	var errs []error
+
+	if len(msg.header["From"]) == 0 {
+		errs = append(errs, errors.New("gomail: \"From\" field is absent"))
+	}
+	if len(msg.header["To"]) == 0 && len(msg.header["Cc"]) == 0 && len(msg.header["Bcc"]) == 0 {
+		errs = append(errs, errors.New("gomail: the message has no recipient"))
+	}
+	if subject := msg.header["Subject"]; len(subject) == 0 || strings.TrimSpace(subject[0]) == "" {
+		errs = append(errs, errors.New("gomail: \"Subject\" field is empty"))
+	}
+
+	for _, field := range addressFields {
+		for _, value := range msg.header[field] {
+			if _, err := mail.ParseAddressList(value); err != nil {
+				errs = append(errs, fmt.Errorf("gomail: invalid address in %q field: %q: %v", field, value, err))
+			}
+		}
+	}
+
+	if msg.hasBody("text/html") && !msg.hasBody("text/plain") {
+		errs = append(errs, errors.New("gomail: the message has an HTML body but no plain text alternative"))
+	}
+
+	for _, p := range msg.parts {
+		if p.charset != "" && !strings.EqualFold(p.charset, "UTF-8") && !is7bit(p.body.Bytes()) && utf8.Valid(p.body.Bytes()) {
+			errs = append(errs, fmt.Errorf("gomail: the %q body is labeled %q but contains UTF-8 text", p.contentType, p.charset))
+		}
+	}
+
+	names := make(map[string]bool, len(msg.attachments))
+	for _, a := range msg.attachments {
+		if isSuspiciousFile(a.name) {
+			errs = append(errs, fmt.Errorf("gomail: attachment %q is likely to be blocked as an executable file", a.name))
+		}
+		if names[a.name] && !msg.renameDups {
+			errs = append(errs, fmt.Errorf("gomail: several attachments are named %q", a.name))
+		}
+		names[a.name] = true
+	}
+
+	return errs
+}
+
+func (msg *Message) hasBody(mediaType string) bool {
+	for _, p := range msg.parts {
+		t, _, err := mime.ParseMediaType(p.contentType)
+		if err == nil && t == mediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// suspiciousExtensions lists the extensions of the files that are commonly
+// blocked by mail servers and clients because they can be executed.
+var suspiciousExtensions = []string{
+	".bat", ".cmd", ".com", ".cpl", ".exe", ".hta", ".jar", ".js", ".jse",
+	".lnk", ".msi", ".pif", ".ps1", ".scr", ".vbe", ".vbs", ".wsf",
+}
+
+func isSuspiciousFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range suspiciousExtensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
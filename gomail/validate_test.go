@@ -0,0 +1,101 @@
+package gomail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	stat = stubStat
+
+	msg := NewMessage()
+	msg.SetAddressHeader("From", "from@example.com", "Señor From")
+	msg.SetHeader("To", "to@example.com")
+	msg.AddAddressHeader("Cc", "cc@example.com", "Señor Cc")
+	msg.SetHeader("Subject", "Hello!")
+	msg.SetBody("text/plain", "Hello")
+	msg.AddAlternative("text/html", "<b>Hello</b>")
+	msg.Attach("/tmp/test.pdf")
+
+	if errs := msg.Validate(); len(errs) != 0 {
+		t.Errorf("Validate should not return errors, got %v", errs)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	stat = stubStat
+
+	tests := []struct {
+		build func(msg *Message)
+		want  []string
+	}{
+		{
+			func(msg *Message) {
+				msg.SetHeader("To", "to@example.com")
+				msg.SetHeader("Subject", "Hello!")
+			},
+			[]string{`"From" field is absent`},
+		},
+		{
+			func(msg *Message) {
+				msg.SetHeader("From", "from@example.com")
+				msg.SetHeader("Subject", "Hello!")
+			},
+			[]string{"no recipient"},
+		},
+		{
+			func(msg *Message) {
+				msg.SetHeader("From", "from@example.com")
+				msg.SetHeader("To", "to@example.com")
+				msg.AddHeader("To", "to.example.com")
+				msg.SetHeader("Subject", " ")
+			},
+			[]string{`"Subject" field is empty`, `invalid address in "To" field: "to.example.com"`},
+		},
+		{
+			func(msg *Message) {
+				msg.SetHeader("From", "from@example.com")
+				msg.SetHeader("Bcc", "bcc@example.com")
+				msg.SetHeader("Subject", "Hello!")
+				msg.SetBody("text/html", "<b>Hello</b>")
+				msg.Attach("/tmp/setup.exe")
+			},
+			[]string{"no plain text alternative", `attachment "setup.exe"`},
+		},
+		{
+			func(msg *Message) {
+				msg.SetHeader("From", "from@example.com")
+				msg.SetHeader("To", "to@example.com")
+				msg.SetHeader("Subject", "Hello!")
+				msg.Attach("/tmp/a/test.pdf")
+				msg.Attach("/tmp/b/test.pdf")
+			},
+			[]string{`several attachments are named "test.pdf"`},
+		},
+		{
+			func(msg *Message) {
+				msg.SetHeader("From", "from@example.com")
+				msg.SetHeader("To", "to@example.com")
+				msg.SetHeader("Subject", "Hello!")
+				msg.SetBody("text/plain", "Café")
+				msg.SetBodyCharset("text/plain", "windows-1252")
+			},
+			[]string{`"text/plain" body is labeled "windows-1252"`},
+		},
+	}
+
+	for i, test := range tests {
+		msg := NewMessage()
+		test.build(msg)
+		errs := msg.Validate()
+		if len(errs) != len(test.want) {
+			t.Errorf("#%d: Validate returned %d errors, want %d: %v", i, len(errs), len(test.want), errs)
+			continue
+		}
+		for j, err := range errs {
+			if !strings.Contains(err.Error(), test.want[j]) {
+				t.Errorf("#%d: invalid error, got %q, want it to contain %q", i, err, test.want[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package gomail
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/alexcesaro/mail/quotedprintable"
+)
+
+// WalkParts walks the MIME parts of a message. Multipart bodies are walked
+// recursively and fn is called for each leaf part with the header of the part
+// and its content decoded according to its Content-Transfer-Encoding.
+func WalkParts(msg *mail.Message, fn func(header textproto.MIMEHeader, body io.Reader) error) error {
+	return walkPart(textproto.MIMEHeader(msg.Header), msg.Body, fn)
+}
+
+func walkPart(h textproto.MIMEHeader, body io.Reader, fn func(textproto.MIMEHeader, io.Reader) error) error {
+	if contentType := h.Get("Content-Type"); contentType != "" {
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(mediaType, "multipart/") {
+			r := multipart.NewReader(body, params["boundary"])
+			for {
+				// NextRawPart is used because NextPart would silently decode
+				// quoted-printable parts and delete their
+				// Content-Transfer-Encoding header.
+				p, err := r.NextRawPart()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := walkPart(p.Header, p, fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return fn(h, decodeBody(h.Get("Content-Transfer-Encoding"), body))
+}
+
+// PartFilename returns the name of the file contained in a part. It is given
+// by the filename parameter of the Content-Disposition field or else by the
+// name parameter of the Content-Type field. Parameters percent-encoded as
+// specified by RFC 2231 are decoded and their continuation segments
+// (filename*0*, filename*1*...) are joined. An empty string is returned if the
+// part has no file name.
+func PartFilename(h textproto.MIMEHeader) (string, error) {
+	for _, f := range []struct{ field, param string }{
+		{"Content-Disposition", "filename"},
+		{"Content-Type", "name"},
+	} {
+		v := h.Get(f.field)
+		if v == "" {
+			continue
+		}
+		_, params, err := mime.ParseMediaType(v)
+		if err != nil {
+			return "", fmt.Errorf("gomail: invalid %s field %q: %v", f.field, v, err)
+		}
+		if name := params[f.param]; name != "" {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+func decodeBody(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case Base64:
+		return NewBase64PartReader(body)
+	case QuotedPrintable:
+		return quotedprintable.NewDecoder(body)
+	}
+
+	return body
+}
+
+// NewBase64PartReader returns a reader decoding the body of a part encoded in
+// base64. The line breaks of the body are removed before it is decoded.
+func NewBase64PartReader(r io.Reader) io.Reader {
+	return base64.NewDecoder(base64.StdEncoding, &newlineSkipper{r})
+}
+
+// newlineSkipper reads from r and removes the CR and LF characters.
+type newlineSkipper struct {
+	r io.Reader
+}
+
+func (s *newlineSkipper) Read(p []byte) (int, error) {
+	for {
+		n, err := s.r.Read(p)
+		j := 0
+		for _, b := range p[:n] {
+			if b != '\r' && b != '\n' {
+				p[j] = b
+				j++
+			}
+		}
+		// A Read must not return 0 bytes without an error
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package gomail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWalkParts(t *testing.T) {
+	raw := "Mime-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=main\r\n" +
+		"\r\n" +
+		"--main\r\n" +
+		"Content-Type: multipart/alternative; boundary=sub\r\n" +
+		"\r\n" +
+		"--sub\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"=C2=A1Hola, se=C3=B1or!\r\n" +
+		"--sub\r\n" +
+		"Content-Type: text/html; charset=UTF-8\r\n" +
+		"\r\n" +
+		"<b>Hola</b>\r\n" +
+		"--sub--\r\n" +
+		"\r\n" +
+		"--main\r\n" +
+		"Content-Type: application/pdf; name=\"test.pdf\"\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"Q29udGVudCBvZiB0\r\n" +
+		"ZXN0LnBkZg==\r\n" +
+		"--main--\r\n"
+
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = WalkParts(msg, func(h textproto.MIMEHeader, body io.Reader) error {
+		content, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		got = append(got, h.Get("Content-Type")+": "+string(content))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"text/plain; charset=UTF-8: ¡Hola, señor!",
+		"text/html; charset=UTF-8: <b>Hola</b>",
+		"application/pdf; name=\"test.pdf\": Content of test.pdf",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Invalid number of parts, got %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Invalid part %d, got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWalkPartsSinglePart(t *testing.T) {
+	msg := NewMessage()
+	msg.SetBody("text/plain", "¡Hola, señor!")
+	m, err := msg.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	err = WalkParts(m, func(h textproto.MIMEHeader, body io.Reader) error {
+		content, err := ioutil.ReadAll(body)
+		got = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "¡Hola, señor!"; got != want {
+		t.Errorf("Invalid body, got %q, want %q", got, want)
+	}
+}
+
+func TestPartFilename(t *testing.T) {
+	tests := []struct {
+		header textproto.MIMEHeader
+		want   string
+	}{
+		{textproto.MIMEHeader{
+			"Content-Disposition": {"attachment; filename*0*=UTF-8''%D1%80%D0%B5; filename*1*=%D0%BF%D0%BE%D1%80%D1%82; filename*2=.pdf"},
+		}, "репорт.pdf"},
+		{textproto.MIMEHeader{
+			"Content-Disposition": {"attachment; filename*=UTF-8''%C3%A9t%C3%A9.txt"},
+		}, "été.txt"},
+		{textproto.MIMEHeader{
+			"Content-Type":        {"application/pdf; name=\"type.pdf\""},
+			"Content-Disposition": {"attachment; filename=\"disposition.pdf\""},
+		}, "disposition.pdf"},
+		{textproto.MIMEHeader{
+			"Content-Type":        {"application/pdf; name*=UTF-8''caf%C3%A9.pdf"},
+			"Content-Disposition": {"inline"},
+		}, "café.pdf"},
+		{textproto.MIMEHeader{"Content-Type": {"text/plain"}}, ""},
+	}
+
+	for _, test := range tests {
+		got, err := PartFilename(test.header)
+		if err != nil {
+			t.Errorf("PartFilename(%v) returned an error: %v", test.header, err)
+		} else if got != test.want {
+			t.Errorf("PartFilename(%v) = %q, want %q", test.header, got, test.want)
+		}
+	}
+
+	h := textproto.MIMEHeader{"Content-Disposition": {"attachment; filename=\"unterminated"}}
+	if _, err := PartFilename(h); err == nil {
+		t.Error("PartFilename should return an error for an invalid field")
+	}
+}
+
+func TestBase64PartReader(t *testing.T) {
+	content := strings.Repeat("Café au lait\n", 20)
+	buf := new(bytes.Buffer)
+	w := base64.NewEncoder(base64.StdEncoding, newBase64LineWriter(buf, maxBase64LineLen))
+	w.Write([]byte(content))
+	w.Close()
+	if !strings.Contains(buf.String(), "\r\n") {
+		t.Fatalf("The encoded content should be wrapped, got %q", buf.String())
+	}
+
+	// The body is read byte by byte so that some reads only return a line
+	// break
+	got, err := ioutil.ReadAll(NewBase64PartReader(iotest.OneByteReader(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("Invalid decoded content, got %q, want %q", got, content)
+	}
+}
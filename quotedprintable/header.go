@@ -14,6 +14,7 @@ import (
 	"io"
 	"regexp"
 	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
@@ -59,7 +60,34 @@ func (e *HeaderEncoder) EncodeHeader(s string) string {
 		return s
 	}
 
-	return e.encodeWord(s)
+	return e.encodeWord(s, false)
+}
+
+// EncodeHeaderSingleWord is like EncodeHeader but the input is encoded into a
+// single encoded-word even if it is longer than the 75 characters allowed by
+// RFC 2047. It should only be used for the clients that cannot decode the
+// encoded-words split on several lines.
+func (e *HeaderEncoder) EncodeHeaderSingleWord(s string) string {
+	if !needsEncoding(s) {
+		return s
+	}
+	single := *e
+	single.splitWords = false
+
+	return single.encodeWord(s, false)
+}
+
+// EncodeHeaderPhrase encodes a string to be used as a phrase in a MIME header
+// value, like the display name of an address. It encodes the input only if it
+// contains non-ASCII characters. In that case, the characters that are not
+// allowed in an encoded-word appearing in a phrase are also encoded (see RFC
+// 2047, section 5).
+func (e *HeaderEncoder) EncodeHeaderPhrase(s string) string {
+	if !needsEncoding(s) {
+		return s
+	}
+
+	return e.encodeWord(s, true)
 }
 
 func needsEncoding(s string) bool {
@@ -72,8 +100,9 @@ func needsEncoding(s string) bool {
 	return false
 }
 
-// encodeWord encodes a string into an encoded-word.
-func (e *HeaderEncoder) encodeWord(s string) string {
+// encodeWord encodes a string into an encoded-word. If phrase is true, the
+// encoded-word can safely be used as a phrase.
+func (e *HeaderEncoder) encodeWord(s string, phrase bool) string {
 	buf := new(bytes.Buffer)
 	e.openWord(buf)
 	if strings.ToUpper(e.encoding) == B {
@@ -99,7 +128,7 @@ func (e *HeaderEncoder) encodeWord(s string) string {
 	} else {
 		if !e.splitWords {
 			for i := 0; i < len(s); i++ {
-				writeQ(buf, s[i])
+				writeQ(buf, s[i], phrase)
 			}
 		} else {
 			var runeSize int
@@ -107,7 +136,7 @@ func (e *HeaderEncoder) encodeWord(s string) string {
 			for i := 0; i < len(s); i += runeSize {
 				b := s[i]
 				var encLen int
-				if b == ' ' || (isVchar(b) && b != '=' && b != '?' && b != '_') {
+				if b == ' ' || isQSafe(b, phrase) {
 					encLen, runeSize = 1, 1
 				} else {
 					runeSize = getRuneSize(s, i)
@@ -118,7 +147,7 @@ func (e *HeaderEncoder) encodeWord(s string) string {
 				if n+encLen > maxEncodedWordLen-2 {
 					n = e.splitWord(buf)
 				}
-				writeQString(buf, s[i:i+runeSize])
+				writeQString(buf, s[i:i+runeSize], phrase)
 				n += encLen
 			}
 		}
@@ -157,17 +186,17 @@ func getRuneSize(s string, i int) int {
 	return runeSize
 }
 
-func writeQString(buf *bytes.Buffer, s string) {
+func writeQString(buf *bytes.Buffer, s string, phrase bool) {
 	for i := 0; i < len(s); i++ {
-		writeQ(buf, s[i])
+		writeQ(buf, s[i], phrase)
 	}
 }
 
-func writeQ(buf *bytes.Buffer, b byte) {
+func writeQ(buf *bytes.Buffer, b byte, phrase bool) {
 	switch {
 	case b == ' ':
 		buf.WriteByte('_')
-	case isVchar(b) && b != '=' && b != '?' && b != '_':
+	case isQSafe(b, phrase):
 		buf.WriteByte(b)
 	default:
 		enc := make([]byte, 3)
@@ -176,12 +205,42 @@ func writeQ(buf *bytes.Buffer, b byte) {
 	}
 }
 
+// isQSafe returns true if b can be written as is in a Q encoded-word. In a
+// phrase, only letters, digits and the characters "!", "*", "+", "-" and "/"
+// are allowed (see RFC 2047, section 5).
+func isQSafe(b byte, phrase bool) bool {
+	if phrase {
+		return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') ||
+			b == '!' || b == '*' || b == '+' || b == '-' || b == '/'
+	}
+
+	return isVchar(b) && b != '=' && b != '?' && b != '_'
+}
+
 // DecodeHeader decodes a MIME header by decoding all encoded-words of the
 // header. This function does not do any charset conversion, the returned text
 // is encoded in the returned charset. So text is not necessarily encoded in
 // UTF-8. As such, this function does not support decoding headers with multiple
-// encoded-words using different charsets.
+// encoded-words using different charsets. The encoded-words using a charset
+// registered with RegisterCharsetDecoder are the exception: they are converted
+// to UTF-8 and the returned charset is "UTF-8".
+//
+// Some encoders write raw 8-bit bytes in Q encoded-words, DecodeHeader leaves
+// them unchanged. Use DecodeHeaderStrict to only decode valid encoded-words.
+// Encoded-words written without separator, like "=?UTF-8?Q?a?==?UTF-8?Q?b?=",
+// are decoded too.
 func DecodeHeader(header string) (text string, charset string, err error) {
+	return decodeHeader(header, false)
+}
+
+// DecodeHeaderStrict is like DecodeHeader but it returns an error if an
+// encoded-word is invalid, like a Q encoded-word containing bytes that are not
+// allowed by RFC 2047, instead of leaving it unchanged.
+func DecodeHeaderStrict(header string) (text string, charset string, err error) {
+	return decodeHeader(header, true)
+}
+
+func decodeHeader(header string, strict bool) (text string, charset string, err error) {
 	var buf bytes.Buffer
 	for {
 		i := strings.IndexByte(header, '=')
@@ -201,15 +260,18 @@ func DecodeHeader(header string) (text string, charset string, err error) {
 		}
 
 		for {
-			dec, wordCharset, err := decodeWord(word)
-			if err != nil {
+			dec, wordCharset, err := decodeWord(word, strict)
+			if err != nil && strict {
+				return "", "", err
+			} else if err != nil {
 				buf.WriteString(word)
 				header = header[len(word):]
 				break
 			}
+			// Charset names are case-insensitive.
 			if charset == "" {
 				charset = wordCharset
-			} else if charset != wordCharset {
+			} else if !strings.EqualFold(charset, wordCharset) {
 				return "", "", fmt.Errorf("quotedprintable: multiple charsets in header are not supported: %q and %q used", charset, wordCharset)
 			}
 			buf.Write(dec)
@@ -225,10 +287,16 @@ func DecodeHeader(header string) (text string, charset string, err error) {
 				// encoded-word there is nothing special to do.
 				break
 			}
-			word = rfc2047.FindString(header[j:])
-			if word == "" {
+			// The white-space is kept if it is not followed by a valid
+			// encoded-word.
+			next := rfc2047.FindString(header[j:])
+			if next == "" {
 				break
 			}
+			if _, _, err := decodeWord(next, strict); err != nil {
+				break
+			}
+			word = next
 			header = header[j:]
 		}
 	}
@@ -237,9 +305,154 @@ func DecodeHeader(header string) (text string, charset string, err error) {
 	return buf.String(), charset, nil
 }
 
+// DecodeAddressHeader decodes a MIME header containing an address list, like
+// the From or To fields. Only the encoded-words appearing in the display names
+// and comments are decoded, the addresses and the quoted strings are left
+// intact as required by RFC 2047, section 5. If a decoded display name contains
+// characters that would change the structure of the list, like a comma, it is
+// returned as a quoted string. Like DecodeHeader, this function only converts
+// the charsets registered with RegisterCharsetDecoder.
+func DecodeAddressHeader(header string) (text string, charset string, err error) {
+	var buf bytes.Buffer
+	start := 0
+	flush := func(end int) error {
+		phrase := header[start:end]
+		dec, phraseCharset, err := DecodeHeader(phrase)
+		if err != nil {
+			return err
+		}
+		if phraseCharset != "" {
+			if charset == "" {
+				charset = phraseCharset
+			} else if !strings.EqualFold(charset, phraseCharset) {
+				return fmt.Errorf("quotedprintable: multiple charsets in header are not supported: %q and %q used", charset, phraseCharset)
+			}
+		}
+		if addsSpecials(phrase, dec) {
+			dec = quotePhrase(dec)
+		}
+		buf.WriteString(dec)
+		return nil
+	}
+
+	for i := 0; i < len(header); i++ {
+		var end int
+		switch header[i] {
+		case '"':
+			end = skipQuotedString(header, i)
+		case '<':
+			if end = strings.IndexByte(header[i:], '>') + i + 1; end == i {
+				end = len(header)
+			}
+		case ',':
+			end = i + 1
+		default:
+			continue
+		}
+
+		if err := flush(i); err != nil {
+			return "", "", err
+		}
+		buf.WriteString(header[i:end])
+		start = end
+		i = end - 1
+	}
+	if err := flush(len(header)); err != nil {
+		return "", "", err
+	}
+
+	return buf.String(), charset, nil
+}
+
+// addsSpecials returns true if decoding the phrase added characters that have a
+// special meaning in an address list.
+func addsSpecials(phrase, dec string) bool {
+	for _, c := range `,;:<>@"\[]` {
+		if strings.Count(dec, string(c)) > strings.Count(phrase, string(c)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipQuotedString returns the index following the quoted string starting at
+// index i of s.
+func skipQuotedString(s string, i int) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+
+	return len(s)
+}
+
+// quotePhrase returns the phrase as a quoted string. The white space
+// surrounding the phrase is kept outside the quotes.
+func quotePhrase(s string) string {
+	text := strings.TrimSpace(s)
+	i := strings.Index(s, text)
+
+	var buf bytes.Buffer
+	buf.WriteString(s[:i])
+	buf.WriteByte('"')
+	for j := 0; j < len(text); j++ {
+		if text[j] == '"' || text[j] == '\\' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(text[j])
+	}
+	buf.WriteByte('"')
+	buf.WriteString(s[i+len(text):])
+
+	return buf.String()
+}
+
+var charsetDecoders = struct {
+	sync.RWMutex
+	m map[string]func([]byte) ([]byte, error)
+}{m: make(map[string]func([]byte) ([]byte, error))}
+
+// RegisterCharsetDecoder registers a function converting text encoded in the
+// given charset to UTF-8. It is then used to convert the encoded-words using
+// this charset decoded by DecodeHeader, DecodeHeaderStrict and
+// DecodeAddressHeader. Charset names are case-insensitive. If dec is nil, the
+// decoder of the charset is removed.
+func RegisterCharsetDecoder(charset string, dec func([]byte) ([]byte, error)) {
+	charset = strings.ToLower(charset)
+	charsetDecoders.Lock()
+	defer charsetDecoders.Unlock()
+	if dec == nil {
+		delete(charsetDecoders.m, charset)
+	} else {
+		charsetDecoders.m[charset] = dec
+	}
+}
+
+// toUTF8 converts text to UTF-8 if a decoder is registered for charset.
+func toUTF8(text []byte, charset string) ([]byte, string, error) {
+	charsetDecoders.RLock()
+	dec := charsetDecoders.m[strings.ToLower(charset)]
+	charsetDecoders.RUnlock()
+	if dec == nil {
+		return text, charset, nil
+	}
+
+	text, err := dec(text)
+	if err != nil {
+		return text, charset, err
+	}
+
+	return text, "UTF-8", nil
+}
+
 var rfc2047 = regexp.MustCompile(`^=\?[\w\-]+\?[bBqQ]\?[^?]+\?=`)
 
-func decodeWord(s string) (text []byte, charset string, err error) {
+func decodeWord(s string, strict bool) (text []byte, charset string, err error) {
 	fields := strings.Split(s, "?")
 	if len(fields) != 5 || fields[0] != "=" || fields[4] != "=" || len(fields[2]) != 1 {
 		return []byte(s), "", nil
@@ -254,18 +467,19 @@ func decodeWord(s string) (text []byte, charset string, err error) {
 			return dec, charset, err
 		}
 	case Q:
-		if dec, err = qDecode(src); err != nil {
+		if dec, err = qDecode(src, strict); err != nil {
 			return dec, charset, err
 		}
 	default:
 		return []byte(""), charset, fmt.Errorf("quotedprintable: RFC 2047 encoding not supported: %q", enc)
 	}
 
-	return dec, charset, nil
+	return toUTF8(dec, charset)
 }
 
-// qDecode decodes a Q encoded string.
-func qDecode(s string) ([]byte, error) {
+// qDecode decodes a Q encoded string. If strict is false, 8-bit bytes are
+// allowed and left unchanged.
+func qDecode(s string, strict bool) ([]byte, error) {
 	dec := make([]byte, MaxDecodedLen(len(s)))
 
 	n := 0
@@ -285,6 +499,8 @@ func qDecode(s string) ([]byte, error) {
 			i += 2
 		case isVchar(c) || c == ' ' || c == '\n' || c == '\r' || c == '\t':
 			dec[n] = c
+		case c >= 0x80 && !strict:
+			dec[n] = c
 		default:
 			return dec[:n], fmt.Errorf("quotedprintable: invalid unescaped byte 0x%02x in Q encoded string", c)
 		}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func ExampleEncodeHeader() {
@@ -70,6 +71,29 @@ func TestEncodeHeader(t *testing.T) {
 	}
 }
 
+func TestEncodeHeaderPhrase(t *testing.T) {
+	utf8, iso88591 := "UTF-8", "iso-8859-1"
+	tests := []struct {
+		charset, encoding, src, exp string
+	}{
+		{utf8, Q, "Señor (Jr.), Smith", "=?UTF-8?Q?Se=C3=B1or_=28Jr=2E=29=2C_Smith?="},
+		{utf8, Q, "Señor+Smith-Jr!", "=?UTF-8?Q?Se=C3=B1or+Smith-Jr!?="},
+		{utf8, B, "Señor (Jr.), Smith", "=?UTF-8?B?U2XDsW9yIChKci4pLCBTbWl0aA==?="},
+		{iso88591, Q, "Rapha\xebl <Dupont>", "=?iso-8859-1?Q?Rapha=EBl_=3CDupont=3E?="},
+		{utf8, Q, "Smith, John", "Smith, John"},
+		{utf8, Q, strings.Repeat("(", 20) + "é", "=?UTF-8?Q?" + strings.Repeat("=28", 20) + "?=\r\n =?UTF-8?Q?=C3=A9?="},
+	}
+
+	for _, test := range tests {
+		e, err := NewHeaderEncoder(test.charset, test.encoding)
+		if err != nil {
+			t.Errorf("NewHeaderEncoder(%q, %q) = error %v, want %v", test.charset, test.encoding, err, error(nil))
+		} else if s := e.EncodeHeaderPhrase(test.src); s != test.exp {
+			t.Errorf("EncodeHeaderPhrase(%q) = %q, want %q", test.src, s, test.exp)
+		}
+	}
+}
+
 func TestDecodeHeader(t *testing.T) {
 	tests := []struct {
 		src, exp, charset string
@@ -92,6 +116,17 @@ func TestDecodeHeader(t *testing.T) {
 		{"=?ISO-8859-1?Q?a?= \r\n\t =?ISO-8859-1?Q?b?=", "ab", "ISO-8859-1", false},
 		{"=?ISO-8859-1?Q?a_b?=", "a b", "ISO-8859-1", false},
 		{"=?ISO-8859-1?Q?a?= =?ISO-8859-2?Q?_b?=", "", "", true},
+		// Only the white-space between two encoded-words is deleted
+		{"=?UTF-8?Q?Hi?=  there", "Hi  there", "UTF-8", false},
+		{"=?UTF-8?Q?Hi?=\r\n there", "Hi\r\n there", "UTF-8", false},
+		{"Hi  =?UTF-8?Q?there?=", "Hi  there", "UTF-8", false},
+		{"=?UTF-8?Q?Hi?=  =?UTF-8?Q?there?=", "Hithere", "UTF-8", false},
+		{"=?UTF-8?Q?Hi?=  =?UTF-8?Q?=A?=", "Hi  =?UTF-8?Q?=A?=", "UTF-8", false},
+		{"=?UTF-8?Q?Hi?=  =?UTF-8?Q?a?= b", "Hia b", "UTF-8", false},
+		// Adjacent encoded-words without separator
+		{"=?UTF-8?Q?a?==?UTF-8?Q?b?=", "ab", "UTF-8", false},
+		{"=?UTF-8?Q?=C3?==?UTF-8?B?qQ==?= x", "é x", "UTF-8", false},
+		{"=?UTF-8?Q?a?==?UTF-8?A?b?=", "a=?UTF-8?A?b?=", "UTF-8", false},
 	}
 
 	for _, test := range tests {
@@ -107,3 +142,124 @@ func TestDecodeHeader(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeHeaderStrict(t *testing.T) {
+	tests := []struct {
+		src, exp, strictExp string
+		strictError         bool
+	}{
+		{"=?UTF-8?Q?Caf=C3=A9?=", "Café", "Café", false},
+		{"=?UTF-8?Q?Caf\xc3\xa9_=C3=A0_la_cr=C3=A8me?=", "Café à la crème", "", true},
+		{"a =?UTF-8?Q?Caf=E?= b", "a =?UTF-8?Q?Caf=E?= b", "", true},
+		{"=?UTF-8?Q?Caf=C3=A9?= =?UTF-8?Q?=ZZ?=", "Café =?UTF-8?Q?=ZZ?=", "", true},
+	}
+
+	for _, test := range tests {
+		s, _, err := DecodeHeader(test.src)
+		if err != nil || s != test.exp {
+			t.Errorf("DecodeHeader(%q) = %q, %v, want %q, nil", test.src, s, err, test.exp)
+		}
+		s, _, err = DecodeHeaderStrict(test.src)
+		if test.strictError && err == nil {
+			t.Errorf("DecodeHeaderStrict(%q) = %q, want an error", test.src, s)
+		} else if !test.strictError && (err != nil || s != test.strictExp) {
+			t.Errorf("DecodeHeaderStrict(%q) = %q, %v, want %q, nil", test.src, s, err, test.strictExp)
+		}
+	}
+}
+
+func TestRegisterCharsetDecoder(t *testing.T) {
+	RegisterCharsetDecoder("ISO-8859-1", func(b []byte) ([]byte, error) {
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return []byte(string(runes)), nil
+	})
+	defer RegisterCharsetDecoder("ISO-8859-1", nil)
+
+	tests := []struct {
+		src, exp, charset string
+	}{
+		{"=?iso-8859-1?B?UmFwaGHrbA==?=", "Raphaël", "UTF-8"},
+		{"=?ISO-8859-1?Q?Fran=E7ois?= =?UTF-8?Q?_J=C3=A9r=C3=B4me?=", "François Jérôme", "UTF-8"},
+		{"=?ISO-8859-2?Q?Rapha=EBl?=", "Rapha\xebl", "ISO-8859-2"},
+		{"=?utf-8?Q?Fran=C3=A7ois?= =?ISO-8859-1?Q?_J=E9r=F4me?=", "François Jérôme", "utf-8"},
+	}
+
+	for _, test := range tests {
+		s, charset, err := DecodeHeader(test.src)
+		if err != nil || s != test.exp || charset != test.charset {
+			t.Errorf("DecodeHeader(%q) = %q (charset=%q, error %v), want %q (charset=%q)", test.src, s, charset, err, test.exp, test.charset)
+		}
+	}
+
+	header := "=?utf-8?Q?Fran=C3=A7ois?= <a@x.com>, =?ISO-8859-1?Q?J=E9r=F4me?= <b@x.com>"
+	s, charset, err := DecodeAddressHeader(header)
+	if want := "François <a@x.com>, Jérôme <b@x.com>"; err != nil || s != want || charset != "utf-8" {
+		t.Errorf("DecodeAddressHeader(%q) = %q (charset=%q, error %v), want %q (charset=%q)", header, s, charset, err, want, "utf-8")
+	}
+}
+
+func FuzzDecodeHeader(f *testing.F) {
+	for _, s := range []string{
+		"=?UTF-8?Q?Fran=C3=A7ois-J=C3=A9r=C3=B4me?=",
+		"=?utf-8?B?QW5kcsOp?=",
+		"=?ISO-8859-1?Q?a?= \r\n\t =?ISO-8859-1?Q?b?=",
+		"=?UTF-8?Q?Hi?=  =?UTF-8?Q?=A?=",
+		"=?UTF-8?B?QW5kc?=",
+		"=?UTF-8?Q?=",
+		"==?=?",
+	} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, header string) {
+		text, _, err := DecodeHeader(header)
+		if err == nil && !strings.Contains(header, "=?") && text != header {
+			t.Errorf("DecodeHeader(%q) = %q, want the header unchanged", header, text)
+		}
+		DecodeHeaderStrict(header)
+		DecodeAddressHeader(header)
+
+		// Text looking like an encoded-word is not encoded by EncodeHeader
+		if !utf8.ValidString(header) || strings.Contains(header, "=?") {
+			return
+		}
+		enc := StdHeaderEncoder.EncodeHeader(header)
+		if text, _, err := DecodeHeader(enc); err != nil || text != header {
+			t.Errorf("DecodeHeader(%q) = %q, %v, want %q, nil", enc, text, err, header)
+		}
+	})
+}
+
+func TestDecodeAddressHeader(t *testing.T) {
+	tests := []struct {
+		src, exp, charset string
+		isError           bool
+	}{
+		{"=?UTF-8?Q?Se=C3=B1or?= <a@x.com>, =?UTF-8?B?QW5kcsOp?= <b@x.com>", "Señor <a@x.com>, André <b@x.com>", "UTF-8", false},
+		{"a@x.com, =?UTF-8?Q?Se=C3=B1or?= =?UTF-8?Q?_To?= <b@x.com>", "a@x.com, Señor To <b@x.com>", "UTF-8", false},
+		{"=?UTF-8?Q?Doe=2C_J=C3=B6hn?= <a@x.com>", `"Doe, Jöhn" <a@x.com>`, "UTF-8", false},
+		{`=?UTF-8?Q?=22J=C3=B6hn=22?= <a@x.com>`, `"\"Jöhn\"" <a@x.com>`, "UTF-8", false},
+		{`"=?UTF-8?Q?a?=" <a@x.com>`, `"=?UTF-8?Q?a?=" <a@x.com>`, "", false},
+		{`"a \" =?UTF-8?Q?a?=" <a@x.com>`, `"a \" =?UTF-8?Q?a?=" <a@x.com>`, "", false},
+		{"<=?UTF-8?Q?a?=@x.com>", "<=?UTF-8?Q?a?=@x.com>", "", false},
+		{"a@x.com (=?UTF-8?Q?Se=C3=B1or?=)", "a@x.com (Señor)", "UTF-8", false},
+		{"Jean <a@x.com>", "Jean <a@x.com>", "", false},
+		{"=?UTF-8?Q?a?= <a@x.com>, =?ISO-8859-1?Q?b?= <b@x.com>", "", "", true},
+	}
+
+	for _, test := range tests {
+		s, charset, err := DecodeAddressHeader(test.src)
+		if test.isError && err == nil {
+			t.Errorf("DecodeAddressHeader(%q) should return an error", test.src)
+		}
+		if !test.isError && err != nil {
+			t.Errorf("DecodeAddressHeader(%q) = error %v, want %v", test.src, err, error(nil))
+		}
+		if s != test.exp || charset != test.charset {
+			t.Errorf("DecodeAddressHeader(%q) = %q (charset=%q), want %q (charset=%q)", test.src, s, charset, test.exp, test.charset)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// This file defines an encoding function for MIME parameter values as defined
+// in RFC 2231.
+
+package quotedprintable
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// maxParamLen is the maximum length of an encoded parameter before it is split
+// into several continuation parameters.
+const maxParamLen = 76
+
+// EncodeParam encodes a MIME header parameter, like the filename of a
+// Content-Disposition header, as defined in RFC 2231. The value is quoted if it
+// only contains ASCII characters and percent-encoded in UTF-8 otherwise:
+//
+//	filename="report.pdf"
+//	filename*=UTF-8''r%C3%A9sum%C3%A9.pdf
+//
+// Long parameters are split into continuation parameters (name*0, name*1, ...)
+// separated by folding white space.
+func EncodeParam(name, value string) string {
+	encoded := needsEncoding(value)
+
+	var param string
+	if encoded {
+		param = name + "*=UTF-8''" + pctEncode(value)
+	} else {
+		param = name + "=" + quoteParam(value)
+	}
+	if len(param) <= maxParamLen {
+		return param
+	}
+
+	return splitParam(name, value, encoded)
+}
+
+// splitParam splits a parameter value into continuation parameters as defined
+// in RFC 2231, section 3. A value is never split inside a UTF-8 character.
+func splitParam(name, value string, encoded bool) string {
+	buf := new(bytes.Buffer)
+	var runeSize int
+	for i, n := 0, 0; i < len(value); n++ {
+		if n > 0 {
+			buf.WriteString(";\r\n ")
+		}
+
+		prefix := name + "*" + strconv.Itoa(n)
+		segLen := len(prefix)
+		if encoded {
+			prefix += "*="
+			if n == 0 {
+				prefix += "UTF-8''"
+			}
+			segLen = len(prefix)
+		} else {
+			prefix += "="
+			// Leave room for the quotes.
+			segLen = len(prefix) + 2
+		}
+
+		j := i
+		for ; j < len(value); j += runeSize {
+			runeSize = getRuneSize(value, j)
+			chunkLen := paramLen(value[j:j+runeSize], encoded)
+			if j > i && segLen+chunkLen > maxParamLen {
+				break
+			}
+			segLen += chunkLen
+		}
+
+		buf.WriteString(prefix)
+		if encoded {
+			buf.WriteString(pctEncode(value[i:j]))
+		} else {
+			buf.WriteString(quoteParam(value[i:j]))
+		}
+		i = j
+	}
+
+	return buf.String()
+}
+
+func paramLen(s string, encoded bool) int {
+	if encoded {
+		return len(pctEncode(s))
+	}
+
+	return len(quoteParam(s)) - 2
+}
+
+// quoteParam returns s as a quoted-string.
+func quoteParam(s string) string {
+	if !strings.ContainsAny(s, `"\`) {
+		return `"` + s + `"`
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+	buf.WriteByte('"')
+
+	return buf.String()
+}
+
+// pctEncode percent-encodes all the characters of s that are not an
+// attribute-char as defined in RFC 2231, section 7.
+func pctEncode(s string) string {
+	buf := new(bytes.Buffer)
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; isAttributeChar(b) {
+			buf.WriteByte(b)
+		} else {
+			buf.WriteByte('%')
+			buf.WriteByte(hextable[b>>4])
+			buf.WriteByte(hextable[b&0x0f])
+		}
+	}
+
+	return buf.String()
+}
+
+func isAttributeChar(b byte) bool {
+	return isVchar(b) && !strings.ContainsRune(`*'%()<>@,;:\"/[]?=`, rune(b))
+}
@@ -0,0 +1,40 @@
+package quotedprintable
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func ExampleEncodeParam() {
+	fmt.Println(EncodeParam("filename", "report.pdf"))
+	fmt.Println(EncodeParam("filename", "résumé.pdf"))
+	// Output:
+	// filename="report.pdf"
+	// filename*=UTF-8''r%C3%A9sum%C3%A9.pdf
+}
+
+func TestEncodeParam(t *testing.T) {
+	tests := []struct {
+		name, value, exp string
+	}{
+		{"filename", "report.pdf", `filename="report.pdf"`},
+		{"filename", `a "b" \ c.txt`, `filename="a \"b\" \\ c.txt"`},
+		{"filename", "a b*c'%.txt", `filename="a b*c'%.txt"`},
+		{"title", "résumé.pdf", "title*=UTF-8''r%C3%A9sum%C3%A9.pdf"},
+		{"title", "a é (1).txt", "title*=UTF-8''a%20%C3%A9%20%281%29.txt"},
+		{"filename", strings.Repeat("0123456789", 10),
+			`filename*0="` + strings.Repeat("0123456789", 6) + `012";` + "\r\n " +
+				`filename*1="3456789` + strings.Repeat("0123456789", 3) + `"`},
+		{"filename", strings.Repeat("é", 20),
+			"filename*0*=UTF-8''" + strings.Repeat("%C3%A9", 9) + ";\r\n " +
+				"filename*1*=" + strings.Repeat("%C3%A9", 10) + ";\r\n " +
+				"filename*2*=%C3%A9"},
+	}
+
+	for _, test := range tests {
+		if s := EncodeParam(test.name, test.value); s != test.exp {
+			t.Errorf("EncodeParam(%q, %q) = %q, want %q", test.name, test.value, s, test.exp)
+		}
+	}
+}
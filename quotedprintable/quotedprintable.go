@@ -4,14 +4,22 @@
 
 // This file defines quoted-printable decoders and encoders, as specified in RFC
 // 2045.
-// Deviations:
+// Deviations of the decoders:
 // 1. in addition to "=\r\n", "=\n" is also treated as soft line break.
-// 2. it will pass through a '\r' or '\n' not preceded by '=', consistent
+// 2. they pass through a '\r' or '\n' not preceded by '=', consistent
 //    with other broken QP encoders & decoders.
+//
+// The encoders do not pass through a '\r' that is not followed by '\n', they
+// encode it as "=0D". A '\n' not preceded by '\r' is written as a line break,
+// except by EncodeStrict which encodes it as "=0A".
 
 // Deprecated, use https://github.com/alexcesaro/quotedprintable instead.
 // Package quotedprintable implements quoted-printable and message header encoding as
 // specified by RFC 2045 and RFC 2047.
+//
+// The writers returned by NewEncoder must be closed: the white-space and the
+// carriage return ending the data written so far are only written once the
+// next bytes are known, or by Close.
 package quotedprintable
 
 import (
@@ -22,10 +30,36 @@ import (
 )
 
 // Encode encodes src into at most MaxEncodedLen(len(src)) bytes to dst,
-// returning the actual number of bytes written to dst.
+// returning the actual number of bytes written to dst. A carriage return that
+// is not followed by a line feed is encoded as "=0D" since it is not a line
+// break and transports could alter it.
+//
+// The white-space ending a line is always encoded, even when it is
+// significant like the trailing space of a format=flowed line: RFC 2045
+// requires decoders to delete the literal white-space ending a line, so only an
+// encoded space like "=20" is decoded back into a space.
 func Encode(dst, src []byte) (n int) {
-	for i := 0; i < len(src); i++ {
+	return encode(dst, src, len(src), false)
+}
+
+// EncodeStrict is like Encode but it also encodes the line feeds that are not
+// preceded by a carriage return as "=0A", so that only CRLF sequences are line
+// breaks.
+func EncodeStrict(dst, src []byte) (n int) {
+	return encode(dst, src, len(src), true)
+}
+
+// encode encodes the first end bytes of src. The following bytes are not
+// encoded, they only tell how the last bytes are encoded.
+func encode(dst, src []byte, end int, strict bool) (n int) {
+	for i := 0; i < end; i++ {
 		switch c := src[i]; {
+		case c == '\r' && !isCRLF(i, src):
+			encodeByte(dst[n:], c)
+			n += 3
+		case c == '\n' && strict && (i == 0 || src[i-1] != '\r'):
+			encodeByte(dst[n:], c)
+			n += 3
 		case c != '=' && (isVchar(c) || isNewline(c)):
 			dst[n] = c
 			n++
@@ -48,15 +82,24 @@ func Encode(dst, src []byte) (n int) {
 
 // isLastChar returns true if byte i is the last character of the line.
 func isLastChar(i int, src []byte) bool {
-	if i == len(src)-1 ||
-		(i < len(src)-1 && src[i+1] == '\n') ||
-		(i < len(src)-2 && src[i+1] == '\r' && src[i+2] == '\n') {
+	switch {
+	case i == len(src)-1:
 		return true
+	case src[i+1] == '\n':
+		return true
+	case src[i+1] == '\r':
+		return isCRLF(i+1, src)
 	}
 
 	return false
 }
 
+// isCRLF returns true if the carriage return at index i of src is followed by a
+// line feed.
+func isCRLF(i int, src []byte) bool {
+	return i+1 < len(src) && src[i+1] == '\n'
+}
+
 // encodeByte encodes a byte using the quoted-printable encoding.
 func encodeByte(dst []byte, b byte) {
 	dst[0] = '='
@@ -77,23 +120,76 @@ func EncodeToString(src []byte) string {
 func MaxEncodedLen(n int) int { return 3 * n }
 
 // NewEncoder returns a new quoted-printable stream encoder. Data written to the
-// returned writer will be encoded and then written to w.
-func NewEncoder(w io.Writer) io.Writer {
-	return &encoder{w}
+// returned writer will be encoded and then written to w. The caller must close
+// the returned writer to flush the white-space and the carriage return ending
+// the data, if any, otherwise they are lost.
+func NewEncoder(w io.Writer) io.WriteCloser {
+	return &encoder{w: w}
 }
 
+// maxChunkLen is the maximum number of bytes encoded at once by an encoder so
+// that large writes use a bounded amount of memory.
+const maxChunkLen = 4096
+
 type encoder struct {
 	w io.Writer
+	// tail is the end of the data written so far that cannot be encoded
+	// before the next bytes are known: a white-space character, encoded only
+	// if it ends a line or the data, followed or not by a carriage return,
+	// encoded unless it is followed by a line feed.
+	tail []byte
+	// src and dst are the buffers used to encode the chunks of data.
+	src, dst []byte
 }
 
 func (e *encoder) Write(p []byte) (int, error) {
-	dbuf := make([]byte, MaxEncodedLen(len(p)))
-	n := Encode(dbuf, p)
-	n, err := e.w.Write(dbuf[:n])
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxChunkLen {
+			chunk = chunk[:maxChunkLen]
+		}
+		nn, err := e.write(chunk)
+		n += nn
+		if err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
+
+// maxTailLen is the maximum length of the tail held by an encoder.
+const maxTailLen = 2
+
+// write encodes p, which is at most maxChunkLen bytes long.
+func (e *encoder) write(p []byte) (int, error) {
+	if e.src == nil {
+		e.src = make([]byte, 0, maxChunkLen+maxTailLen)
+		e.dst = make([]byte, MaxEncodedLen(maxChunkLen+maxTailLen))
+		e.tail = make([]byte, 0, maxTailLen)
+	}
+
+	held := len(e.tail)
+	src := append(append(e.src[:0], e.tail...), p...)
+	end := len(src)
+	if end > 0 && src[end-1] == '\r' {
+		end--
+	}
+	if end > 0 && isWSP(src[end-1]) {
+		end--
+	}
+	e.tail = append(e.tail[:0], src[end:]...)
+
+	// The tail is encoded with the next bytes but it tells how the bytes
+	// preceding it are encoded.
+	n := encode(e.dst, src, end, false)
+	n, err := e.w.Write(e.dst[:n])
 	if err != nil {
 		nn := 0
 		for i := 0; i < n; i++ {
-			if dbuf[i] == '=' {
+			if e.dst[i] == '=' {
 				if i+2 >= n {
 					break
 				}
@@ -101,12 +197,30 @@ func (e *encoder) Write(p []byte) (int, error) {
 			}
 			nn++
 		}
+		// The tail held from the previous call was already counted
+		if nn -= held; nn < 0 {
+			nn = 0
+		}
 		return nn, err
 	}
 
 	return len(p), nil
 }
 
+// Close encodes the white-space and the carriage return ending the data, if
+// any. It does not close the underlying writer.
+func (e *encoder) Close() error {
+	if len(e.tail) == 0 {
+		return nil
+	}
+	dbuf := make([]byte, MaxEncodedLen(len(e.tail)))
+	n := Encode(dbuf, e.tail)
+	e.tail = e.tail[:0]
+	_, err := e.w.Write(dbuf[:n])
+
+	return err
+}
+
 // Decode decodes src into at most MaxDecodedLen(len(src)) bytes to dst,
 // returning the actual number of bytes written to dst.
 func Decode(dst, src []byte) (n int, err error) {
@@ -196,6 +310,13 @@ func NewDecoder(r io.Reader) io.Reader {
 	return &qpReader{br: bufio.NewReader(r)}
 }
 
+// DecodeTo decodes the quoted-printable data read from r and writes it to w
+// until EOF is reached or an error occurs. It returns the number of bytes
+// written and the first error encountered, if any.
+func DecodeTo(w io.Writer, r io.Reader) (int64, error) {
+	return io.Copy(w, NewDecoder(r))
+}
+
 type qpReader struct {
 	br   *bufio.Reader
 	line []byte
@@ -214,15 +335,28 @@ func (q *qpReader) Read(p []byte) (int, error) {
 			}
 
 			q.line, q.err = q.br.ReadSlice('\n')
+			if q.err == bufio.ErrBufferFull {
+				// The line does not fit in the buffer, the rest of the line is
+				// read so that it can be decoded at once.
+				line := append([]byte(nil), q.line...)
+				for q.err == bufio.ErrBufferFull {
+					q.line, q.err = q.br.ReadSlice('\n')
+					line = append(line, q.line...)
+				}
+				q.line = line
+			}
 			if q.err == io.EOF {
 				q.eof = true
-			} else if q.err != nil {
-				return n, q.err
+				q.err = nil
 			}
 
-			var nn int
-			nn, q.err = Decode(q.line, q.line)
+			// The bytes read before an error are decoded and returned before
+			// the error. A read error takes precedence over a decoding error.
+			nn, err := Decode(q.line, q.line)
 			q.line = q.line[:nn]
+			if q.err == nil {
+				q.err = err
+			}
 		}
 
 		nn := copy(p[n:], q.line)
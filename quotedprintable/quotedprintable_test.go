@@ -14,9 +14,11 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -44,6 +46,7 @@ func ExampleNewEncoder() {
 	input := []byte("Café")
 	encoder := NewEncoder(os.Stdout)
 	encoder.Write(input)
+	encoder.Close()
 	// Output:
 	// Caf=C3=A9
 }
@@ -116,6 +119,69 @@ func TestQuotedPrintable(t *testing.T) {
 
 }
 
+func TestDecodeTo(t *testing.T) {
+	tests := []string{
+		"",
+		"=C2=A1Hola, se=C3=B1or!\r\n" +
+			"Now's the time =\r\n" +
+			"for all folk to come=\r\n" +
+			" to the aid of their country.\r\n",
+		// Lines longer than the buffer of the decoder
+		strings.Repeat("foo=3D", 2000) + "  \r\nbar=\r\n" + strings.Repeat("=C3=A9", 2000),
+	}
+
+	for _, in := range tests {
+		want, err := DecodeString(in)
+		if err != nil {
+			t.Fatalf("DecodeString(%q) returned error: %v", in, err)
+		}
+
+		var buf bytes.Buffer
+		n, err := DecodeTo(&buf, strings.NewReader(in))
+		if err != nil {
+			t.Errorf("DecodeTo(%q) returned error: %v", in, err)
+		}
+		if got := buf.String(); got != string(want) {
+			t.Errorf("DecodeTo(%q) wrote %q, want %q", in, got, want)
+		}
+		if n != int64(len(want)) {
+			t.Errorf("DecodeTo(%q) = %d, want %d", in, n, len(want))
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := DecodeTo(&buf, strings.NewReader("foo=\r\nbar=ZZ")); err == nil {
+		t.Error("DecodeTo should return an error when the input is invalid")
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	readErr := errors.New("read error")
+	tests := []struct {
+		in   io.Reader
+		want string
+		err  error
+	}{
+		{strings.NewReader("=\r\nfoo=\r\n=\r\n=\r\nbar=\r\n"), "foobar", nil},
+		{strings.NewReader("foo=\r\n=\r\nbar\r\nb=ZZ\r\nbaz\r\n"), "foobar\r\nb", errors.New("quotedprintable: invalid quoted-printable hex byte 0x5a")},
+		{io.MultiReader(strings.NewReader("caf=C3=A9=\r\n=\r\nbar=3D"), iotest.ErrReader(readErr)), "cafébar=", readErr},
+		{iotest.TimeoutReader(strings.NewReader("foo=3D=\r\nbar")), "foo=bar", iotest.ErrTimeout},
+	}
+
+	for _, test := range tests {
+		// The decoded text is read byte by byte so that the lines are
+		// returned over several calls to Read.
+		var buf bytes.Buffer
+		_, err := io.Copy(&buf, iotest.OneByteReader(NewDecoder(test.in)))
+		if got := buf.String(); got != test.want {
+			t.Errorf("Decoder read %q, want %q", got, test.want)
+		}
+		if fmt.Sprint(err) != fmt.Sprint(test.err) {
+			t.Errorf("Decoder returned error %v, want %v", err, test.err)
+		}
+	}
+}
+
 func everySequence(base, alpha string, length int, fn func(string)) {
 	if len(base) == length {
 		fn(base)
@@ -247,6 +313,10 @@ func TestEncodeToString(t *testing.T) {
 		{in: "foo bar  \n", want: "foo bar =20\n"},
 		{in: "foo bar  \n ", want: "foo bar =20\n=20"},
 		{in: "résumé", want: "r=C3=A9sum=C3=A9"},
+		{in: "foo\rbar", want: "foo=0Dbar"},
+		{in: "foo\r", want: "foo=0D"},
+		{in: "foo\r\r\nbar", want: "foo=0D\r\nbar"},
+		{in: "foo\nbar", want: "foo\nbar"},
 		{in: "\t !\"#$%&'()*+,-./ :;<>?@[\\]^_`{|}~", want: "\t !\"#$%&'()*+,-./ :;<>?@[\\]^_`{|}~"},
 	}
 
@@ -258,6 +328,175 @@ func TestEncodeToString(t *testing.T) {
 	}
 }
 
+func TestEncodeStrict(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "foo\r\nbar", want: "foo\r\nbar"},
+		{in: "foo\nbar", want: "foo=0Abar"},
+		{in: "\nfoo", want: "=0Afoo"},
+		{in: "foo\rbar", want: "foo=0Dbar"},
+		{in: "foo\n\r\n", want: "foo=0A\r\n"},
+	}
+
+	for _, tt := range tests {
+		dst := make([]byte, MaxEncodedLen(len(tt.in)))
+		if got := string(dst[:EncodeStrict(dst, []byte(tt.in))]); got != tt.want {
+			t.Errorf("EncodeStrict(%q), got %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderSplitCRLF(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewEncoder(buf)
+	w.Write([]byte("foo\r"))
+	w.Write([]byte("\nbar\rbaz"))
+	if got, want := buf.String(), "foo\r\nbar=0Dbaz"; got != want {
+		t.Errorf("Invalid encoding of a split CRLF, got %q; want %q", got, want)
+	}
+}
+
+func TestEncoderSplitCR(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{in: []string{"a\r", "b"}, want: "a=0Db"},
+		{in: []string{"a\r"}, want: "a=0D"},
+		{in: []string{"a\r", "", "\nb"}, want: "a\r\nb"},
+		{in: []string{"a\r", "\r", "\n"}, want: "a=0D\r\n"},
+		{in: []string{"a \r", "b"}, want: "a =0Db"},
+		{in: []string{"a \r"}, want: "a =0D"},
+		{in: []string{"a ", "\r", "\n"}, want: "a=20\r\n"},
+	}
+
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		w := NewEncoder(buf)
+		for _, s := range tt.in {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("Write(%q) = %d, %v; want %d, nil", s, n, err, len(s))
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("Encoding %q, got %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderChunkBoundary(t *testing.T) {
+	prefix := strings.Repeat("a", maxChunkLen-1)
+	tests := []struct {
+		in, want string
+	}{
+		{in: prefix + "\rb", want: prefix + "=0Db"},
+		{in: prefix + "\r\nb", want: prefix + "\r\nb"},
+		{in: prefix + "\r\rb", want: prefix + "=0D=0Db"},
+		{in: prefix + " \r\nb", want: prefix + "=20\r\nb"},
+		{in: prefix + "\r", want: prefix + "=0D"},
+	}
+
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		w := NewEncoder(buf)
+		if _, err := w.Write([]byte(tt.in)); err != nil {
+			t.Error(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("Encoding %q, got %q; want %q", tt.in[len(prefix)-1:], got[len(prefix)-1:], tt.want[len(prefix)-1:])
+		}
+	}
+}
+
+func TestEncoderTrailingWhiteSpace(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{in: []string{"a ", "", ""}, want: "a=20"},
+		{in: []string{"a \t", "b"}, want: "a \tb"},
+		{in: []string{"a ", " "}, want: "a =20"},
+		{in: []string{"a ", "\r\nb\t"}, want: "a=20\r\nb=09"},
+		{in: []string{" ", " ", "\n"}, want: " =20\n"},
+		{in: []string{"a  \r", "\nb"}, want: "a =20\r\nb"},
+	}
+
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		w := NewEncoder(buf)
+		for _, s := range tt.in {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Errorf("Write(%q) = %d, %v; want %d, nil", s, n, err, len(s))
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("Encoding %q, got %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFlowedTrailingSpace(t *testing.T) {
+	// The trailing space of a flowed line means the line continues on the
+	// next one (RFC 3676), it must survive the encoding.
+	flowed := "This line is \r\ncontinued.\r\n"
+	encoded := EncodeToString([]byte(flowed))
+	if want := "This line is=20\r\ncontinued.\r\n"; encoded != want {
+		t.Errorf("EncodeToString(%q) = %q; want %q", flowed, encoded, want)
+	}
+	if got, err := DecodeString(encoded); err != nil || string(got) != flowed {
+		t.Errorf("DecodeString(%q) = %q, %v; want %q, nil", encoded, got, err, flowed)
+	}
+
+	// A literal trailing space would be deleted by decoders.
+	if got, err := DecodeString(flowed); err != nil || string(got) != "This line is\r\ncontinued.\r\n" {
+		t.Errorf("DecodeString(%q) = %q, %v; want the trailing space removed", flowed, got, err)
+	}
+}
+
+func TestEncoderLargeWrite(t *testing.T) {
+	src := bytes.Repeat([]byte("Caf\xe9 au lait \r\n\tcr\xe8me = \r\n"), 10<<20/25)
+
+	buf := bytes.NewBuffer(make([]byte, 0, MaxEncodedLen(len(src))))
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	w := NewEncoder(buf)
+	if n, err := w.Write(src); n != len(src) || err != nil {
+		t.Fatalf("Write() = %d, %v; want %d, nil", n, err, len(src))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("Encoding %d bytes allocated %d bytes; want at most %d", len(src), alloc, 1<<20)
+	}
+	if got, want := buf.String(), EncodeToString(src); got != want {
+		t.Error("Encoding a large buffer with an encoder differs from EncodeToString")
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	src := bytes.Repeat([]byte("Caf\xe9 au lait \r\n\tcr\xe8me = \r\n"), 4000)
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		w := NewEncoder(io.Discard)
+		w.Write(src)
+		w.Close()
+	}
+}
+
 type brokenWriter struct {
 	errorByte int
 	*bytes.Buffer